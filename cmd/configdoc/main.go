@@ -0,0 +1,59 @@
+// Command configdoc generates the reference of the config.PostgresDatabase settings, as a
+// Markdown table or as a commented .env template:
+//
+//	go run ./cmd/configdoc -format markdown -o docs/configuration.md
+//	go run ./cmd/configdoc -format dotenv -prefix DB_ -o .env.example
+//
+// Services document their own configuration structs with config.NewReference.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pixime-net/mapbot-shared/config"
+)
+
+func main() {
+	format := flag.String("format", "markdown", "output format: markdown or dotenv")
+	prefix := flag.String("prefix", config.DefaultEnvPrefix, "environment variable prefix")
+	output := flag.String("o", "", "output file (default: standard output)")
+	flag.Parse()
+
+	if err := run(*format, *prefix, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "configdoc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(format, prefix, output string) error {
+	ref, err := config.NewReference(config.PostgresDatabase{}, prefix)
+	if err != nil {
+		return err
+	}
+
+	var write func(io.Writer) error
+	switch format {
+	case "markdown":
+		write = ref.WriteMarkdown
+	case "dotenv":
+		write = ref.WriteDotEnv
+	default:
+		return fmt.Errorf("unknown format %q, expected markdown or dotenv", format)
+	}
+
+	if output == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(output) // #nosec G304 -- path given on the command line
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
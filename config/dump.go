@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// SourceKind is the kind of source a setting got its value from
+type SourceKind string
+
+// Sources of a setting, in increasing order of precedence
+const (
+	SourceDefault  SourceKind = "default"  // default tag, or value already in the struct
+	SourceFile     SourceKind = "file"     // configuration file or .env file
+	SourceEnv      SourceKind = "env"      // environment variable
+	SourceOverride SourceKind = "override" // WithOverrides
+)
+
+// Source tells where the value of a setting came from
+type Source struct {
+	Kind SourceKind
+	Name string // file name ("service.yaml", ".env DB_PORT") or variable name, if any
+}
+
+// String returns "default", the file name, "env NAME" or "override"
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceFile:
+		return s.Name
+	case SourceEnv:
+		return "env " + s.Name
+	default:
+		return string(s.Kind)
+	}
+}
+
+// Sources maps the dotted key of each setting to its Source, see WithSources
+type Sources map[string]Source
+
+// DumpEntry is the effective value of a setting
+type DumpEntry struct {
+	Key    string     `json:"key"`
+	Value  string     `json:"value"`
+	Secret bool       `json:"secret,omitempty"`
+	Source SourceKind `json:"source,omitempty"`
+	Origin string     `json:"origin,omitempty"` // Name of the Source
+}
+
+// Dump renders the effective settings of a configuration for debugging, as a slog group
+// (it implements slog.LogValuer), as JSON or as a table. Settings tagged secret are
+// shown as "****" unless they are empty, like PublicConnectionString shows the password.
+type Dump struct {
+	Entries []DumpEntry
+}
+
+// NewDump lists the settings of cfg, a pointer to a struct tagged for Load such as a
+// PostgresDatabase, annotated with their source from sources (see WithSources), which
+// may be nil when the sources are unknown:
+//
+//	sources := config.Sources{}
+//	err := config.Load(&cfg, config.WithFile("service.yaml"), config.WithSources(sources))
+//	dump, err := config.NewDump(&cfg, sources)
+//	logger.Info("Configuration loaded", "config", dump)
+func NewDump(cfg any, sources Sources) (*Dump, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config.NewDump needs a non-nil pointer to a struct, got %T", cfg)
+	}
+	// Work on a copy, since collectSettings allocates nil sections
+	copied := reflect.New(v.Elem().Type())
+	copied.Elem().Set(v.Elem())
+
+	dump := &Dump{}
+	for _, s := range collectSettings(copied.Elem(), "", "") {
+		entry := DumpEntry{Key: s.key, Secret: s.secret}
+		if s.secret {
+			if !s.field.IsZero() {
+				entry.Value = "****"
+			}
+		} else {
+			entry.Value = formatField(s.field)
+		}
+		if source, ok := sources[s.key]; ok {
+			entry.Source = source.Kind
+			entry.Origin = source.Name
+		}
+		dump.Entries = append(dump.Entries, entry)
+	}
+	return dump, nil
+}
+
+// LogValue implements slog.LogValuer with a group per section and, for each setting, a
+// group holding its value, source and origin
+func (d *Dump) LogValue() slog.Value {
+	return slog.GroupValue(dumpAttrs(d.Entries, "")...)
+}
+
+// dumpAttrs builds the attributes of the entries whose key starts with prefix, in order
+func dumpAttrs(entries []DumpEntry, prefix string) []slog.Attr {
+	var attrs []slog.Attr
+	for i := 0; i < len(entries); i++ {
+		name, _, isSection := strings.Cut(strings.TrimPrefix(entries[i].Key, prefix), ".")
+		if !isSection {
+			entry := entries[i]
+			values := []slog.Attr{slog.String("value", entry.Value)}
+			if entry.Source != "" {
+				values = append(values, slog.String("source", string(entry.Source)))
+			}
+			if entry.Origin != "" {
+				values = append(values, slog.String("origin", entry.Origin))
+			}
+			attrs = append(attrs, slog.Attr{Key: name, Value: slog.GroupValue(values...)})
+			continue
+		}
+		sectionPrefix := prefix + name + "."
+		end := i
+		for end < len(entries) && strings.HasPrefix(entries[end].Key, sectionPrefix) {
+			end++
+		}
+		attrs = append(attrs, slog.Attr{Key: name, Value: slog.GroupValue(dumpAttrs(entries[i:end], sectionPrefix)...)})
+		i = end - 1
+	}
+	return attrs
+}
+
+// MarshalJSON renders the entries as a JSON array
+func (d *Dump) MarshalJSON() ([]byte, error) {
+	if d.Entries == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(d.Entries)
+}
+
+// WriteTable writes the entries as an aligned table with KEY, VALUE and SOURCE columns
+func (d *Dump) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE"); err != nil {
+		return err
+	}
+	for _, entry := range d.Entries {
+		source := string(entry.Source)
+		if source == "" {
+			source = "-"
+		}
+		if entry.Origin != "" {
+			source += " (" + entry.Origin + ")"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Key, entry.Value, source); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
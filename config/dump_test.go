@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// loadDump loads a serviceConfig from every kind of source and dumps it
+func loadDump(t *testing.T) *Dump {
+	t.Helper()
+	file := writeConfigFile(t, "service.yaml", `
+workers: 8
+database:
+  host: db.example.com
+  name: mapbot
+  user: mapbot
+  password: hunter2
+`)
+	dotEnv := writeConfigFile(t, ".env", "DUMP_DB_SSLMODE=require\n")
+	t.Setenv("DUMP_LISTEN", ":9002")
+
+	var cfg serviceConfig
+	sources := Sources{}
+	err := Load(&cfg, WithFile(file), WithDotEnv(dotEnv), WithEnvPrefix("DUMP_"),
+		WithOverrides(map[string]string{"database.port": "5436"}), WithSources(sources))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	dump, err := NewDump(&cfg, sources)
+	if err != nil {
+		t.Fatalf("NewDump() error = %v", err)
+	}
+	return dump
+}
+
+// TestNewDump tests values, sources and redaction
+func TestNewDump(t *testing.T) {
+	dump := loadDump(t)
+
+	entries := make(map[string]DumpEntry)
+	for _, entry := range dump.Entries {
+		entries[entry.Key] = entry
+	}
+	want := []DumpEntry{
+		{Key: "timeout", Value: "30s", Source: SourceDefault},
+		{Key: "workers", Value: "8", Source: SourceFile, Origin: "service.yaml"},
+		{Key: "listen", Value: ":9002", Source: SourceEnv, Origin: "DUMP_LISTEN"},
+		{Key: "database.sslmode", Value: "require", Source: SourceFile, Origin: ".env DUMP_DB_SSLMODE"},
+		{Key: "database.port", Value: "5436", Source: SourceOverride},
+		{Key: "database.password", Value: "****", Secret: true, Source: SourceFile, Origin: "service.yaml"},
+		{Key: "database.sslkey_pem", Value: "", Secret: true, Source: SourceDefault},
+	}
+	for _, w := range want {
+		if got := entries[w.Key]; got != w {
+			t.Errorf("entry %s = %+v, want %+v", w.Key, got, w)
+		}
+	}
+	if dump.Entries[0].Key != "listen" {
+		t.Errorf("first entry = %s, want the first field", dump.Entries[0].Key)
+	}
+
+	if _, err := NewDump(serviceConfig{}, nil); err == nil {
+		t.Error("NewDump() of a struct value should fail")
+	}
+}
+
+// TestNewDumpDoesNotModify tests that dumping a configuration with nil sections leaves it unchanged
+func TestNewDumpDoesNotModify(t *testing.T) {
+	var cfg serviceConfig
+	dump, err := NewDump(&cfg, nil)
+	if err != nil {
+		t.Fatalf("NewDump() error = %v", err)
+	}
+	if cfg.Database != nil {
+		t.Error("NewDump() allocated the database section")
+	}
+	if entry := dump.Entries[len(dump.Entries)-1]; entry.Source != "" || !strings.HasPrefix(entry.Key, "database.") {
+		t.Errorf("last entry = %+v, want a database setting without source", entry)
+	}
+}
+
+// TestDumpFormats tests the slog, JSON and table renderings
+func TestDumpFormats(t *testing.T) {
+	dump := loadDump(t)
+
+	var logged bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logged, nil)).Info("loaded", "config", dump)
+	var record struct {
+		Config struct {
+			Workers  map[string]string            `json:"workers"`
+			Database map[string]map[string]string `json:"database"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(logged.Bytes(), &record); err != nil {
+		t.Fatalf("log output %s: %v", logged.String(), err)
+	}
+	if w := record.Config.Workers; w["value"] != "8" || w["source"] != "file" || w["origin"] != "service.yaml" {
+		t.Errorf("logged workers = %v", w)
+	}
+	if port := record.Config.Database["port"]; port["value"] != "5436" || port["source"] != "override" {
+		t.Errorf("logged database.port = %v", port)
+	}
+
+	encoded, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(encoded), `{"key":"database.password","value":"****","secret":true,"source":"file","origin":"service.yaml"}`) {
+		t.Errorf("JSON = %s", encoded)
+	}
+
+	var table bytes.Buffer
+	if err := dump.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	if !strings.Contains(table.String(), "database.port") || !strings.Contains(table.String(), "env (DUMP_LISTEN)") {
+		t.Errorf("table =\n%s", table.String())
+	}
+
+	for name, output := range map[string]string{"slog": logged.String(), "JSON": string(encoded), "table": table.String()} {
+		if strings.Contains(output, "hunter2") {
+			t.Errorf("%s output leaks the password", name)
+		}
+	}
+}
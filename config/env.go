@@ -0,0 +1,238 @@
+package config
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultEnvPrefix is the conventional prefix for the primary database of a service
+// (DB_HOST, DB_PORT, DB_NAME, DB_USER, DB_PASSWORD, ...)
+const DefaultEnvPrefix = "DB_"
+
+// EnvVarError describes an environment variable whose value could not be parsed
+type EnvVarError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *EnvVarError) Error() string {
+	return fmt.Sprintf("%s=%q: %v", e.Name, e.Value, e.Err)
+}
+
+// Unwrap returns the underlying parse error
+func (e *EnvVarError) Unwrap() error {
+	return e.Err
+}
+
+// EnvError lists every missing or malformed variable found while loading configuration
+// from the environment, so that all problems can be fixed at once
+type EnvError struct {
+	Missing   []string
+	Malformed []*EnvVarError
+}
+
+// Error implements the error interface
+func (e *EnvError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	for _, m := range e.Malformed {
+		parts = append(parts, "malformed "+m.Error())
+	}
+	return "invalid environment configuration: " + strings.Join(parts, "; ")
+}
+
+func (e *EnvError) empty() bool {
+	return len(e.Missing) == 0 && len(e.Malformed) == 0
+}
+
+// LoadPostgresDatabaseFromEnv builds a PostgresDatabase from environment variables.
+// Every variable name is the given prefix followed by the field's env tag, e.g. with
+// DefaultEnvPrefix:
+//
+//	DB_HOST, DB_NAME, DB_USER  required
+//	DB_PORT                    default 5432
+//	DB_PASSWORD
+//	DB_SSLMODE                 default "disable"
+//	DB_MAX_OPEN_CONNS          default 25
+//	DB_MAX_IDLE_CONNS          default 5
+//	DB_CONN_MAX_LIFETIME       duration, default 5m (plain integers are minutes)
+//	DB_CONN_MAX_IDLE_TIME      duration, default 30s (plain integers are seconds)
+//	DB_CONNECT_TIMEOUT         duration, default 10s
+//	DB_PING_TIMEOUT            deprecated, in seconds, overrides DB_CONNECT_TIMEOUT
+//
+// as well as the TLS settings DB_SSLROOTCERT, DB_SSLROOTCERT_PEM, DB_SSLCERT, DB_SSLCERT_PEM,
+// DB_SSLKEY, DB_SSLKEY_PEM, DB_SSL_SERVER_NAME and DB_SSL_MIN_PROTOCOL_VERSION, and the
+// failover settings DB_FALLBACK_HOSTS (e.g. "standby1:5433,standby2") and
+// DB_TARGET_SESSION_ATTRS, and the session settings DB_APPLICATION_NAME, DB_SEARCH_PATH,
+// DB_STATEMENT_TIMEOUT, DB_LOCK_TIMEOUT (plain integers in milliseconds) and
+// DB_RUNTIME_PARAMS (e.g. "work_mem=64MB,jit=off").
+//
+// Variables from a .env file in the working directory are loaded first without
+// overriding the environment, like the logger package does. Unset or empty variables keep
+// the defaults of NewPostgresDatabaseForProfile for the profile named by MAPBOT_ENV, which
+// may also be set in .env. DB_PASSWORD and DB_SSLKEY_PEM may be secret
+// references such as file:///run/secrets/db_password, resolved by NewSecretResolver.
+// The returned error is an *EnvError.
+func LoadPostgresDatabaseFromEnv(prefix string) (*PostgresDatabase, error) {
+	_ = godotenv.Load()
+
+	profile, err := ParseProfile(os.Getenv(ProfileEnvVar))
+	if err != nil {
+		return nil, &EnvError{Malformed: []*EnvVarError{{Name: ProfileEnvVar, Value: os.Getenv(ProfileEnvVar), Err: err}}}
+	}
+	cfg := NewPostgresDatabaseForProfile(profile, "", 5432, "", "", "")
+	if err := decodeEnv(cfg, prefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := resolveEnvSecrets(cfg, prefix, NewSecretResolver()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolveEnvSecrets resolves the secret references of dst, reporting failures by
+// variable name
+func resolveEnvSecrets(dst any, prefix string, resolver *SecretResolver) error {
+	envErr := &EnvError{}
+	for _, s := range collectSettings(reflect.ValueOf(dst).Elem(), "", prefix) {
+		if !s.secret || s.field.Kind() != reflect.String {
+			continue
+		}
+		ref := s.field.String()
+		secret, err := resolver.Resolve(context.Background(), ref)
+		if err != nil {
+			envErr.Malformed = append(envErr.Malformed, &EnvVarError{Name: s.env, Value: ref, Err: err})
+			continue
+		}
+		s.field.SetString(secret)
+	}
+
+	if envErr.empty() {
+		return nil
+	}
+	return envErr
+}
+
+// decodeEnv fills the env-tagged fields of the struct pointed to by dst.
+// An env tag has the form "NAME" or "NAME,required".
+func decodeEnv(dst any, prefix string, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	envErr := &EnvError{}
+
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("env")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		name = prefix + name
+
+		raw, ok := lookup(name)
+		if !ok || raw == "" {
+			if opts == "required" {
+				envErr.Missing = append(envErr.Missing, name)
+			}
+			continue
+		}
+
+		if err := setField(v.Field(i), raw, t.Field(i).Tag.Get("unit")); err != nil {
+			envErr.Malformed = append(envErr.Malformed, &EnvVarError{Name: name, Value: raw, Err: err})
+		}
+	}
+
+	if envErr.empty() {
+		return nil
+	}
+	return envErr
+}
+
+// durationUnits maps the values of the unit tag to the unit of plain integer durations
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// setField parses raw according to the type of field and stores the result.
+// Durations use the time.ParseDuration syntax; when unit is set, a plain integer is also
+// accepted and read in that unit. Types implementing encoding.TextUnmarshaler parse
+// themselves, slices are comma-separated lists of their element type and maps
+// comma-separated lists of key=value.
+func setField(field reflect.Value, raw, unit string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			n, intErr := strconv.ParseInt(raw, 10, 64)
+			if unit == "" || intErr != nil {
+				return fmt.Errorf("not a duration")
+			}
+			d = time.Duration(n) * durationUnits[unit]
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("not an integer")
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		items := strings.Split(raw, ",")
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), strings.TrimSpace(item), unit); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		field.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not key=value", strings.TrimSpace(pair))
+			}
+			key := reflect.New(field.Type().Key()).Elem()
+			if err := setField(key, strings.TrimSpace(k), ""); err != nil {
+				return fmt.Errorf("key %s: %w", strings.TrimSpace(k), err)
+			}
+			value := reflect.New(field.Type().Elem()).Elem()
+			if err := setField(value, strings.TrimSpace(v), unit); err != nil {
+				return fmt.Errorf("value of %s: %w", strings.TrimSpace(k), err)
+			}
+			m.SetMapIndex(key, value)
+		}
+		field.Set(m)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadPostgresDatabaseFromEnv tests loading a full configuration from prefixed variables
+func TestLoadPostgresDatabaseFromEnv(t *testing.T) {
+	t.Setenv("TEST_DB_HOST", "db.example.com")
+	t.Setenv("TEST_DB_PORT", "5433")
+	t.Setenv("TEST_DB_NAME", "mapbot")
+	t.Setenv("TEST_DB_USER", "admin")
+	t.Setenv("TEST_DB_PASSWORD", "secret")
+	t.Setenv("TEST_DB_SSLMODE", "require")
+	t.Setenv("TEST_DB_MAX_OPEN_CONNS", "50")
+	t.Setenv("TEST_DB_MAX_IDLE_CONNS", "10")
+	t.Setenv("TEST_DB_CONN_MAX_LIFETIME", "15m")
+	t.Setenv("TEST_DB_CONN_MAX_IDLE_TIME", "90s")
+	t.Setenv("TEST_DB_CONNECT_TIMEOUT", "3s")
+
+	db, err := LoadPostgresDatabaseFromEnv("TEST_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+
+	want := &PostgresDatabase{
+		Host:            "db.example.com",
+		Port:            5433,
+		Database:        "mapbot",
+		User:            "admin",
+		Password:        "secret",
+		SSLMode:         "require",
+		MaxOpenConns:    50,
+		MaxIdleConns:    10,
+		MaxConnLifetime: 15 * time.Minute,
+		MaxConnIdleTime: 90 * time.Second,
+		ConnectTimeout:  3 * time.Second,
+		Profile:         ProfileDev,
+	}
+	if !reflect.DeepEqual(db, want) {
+		t.Errorf("LoadPostgresDatabaseFromEnv() = %+v, want %+v", *db, *want)
+	}
+}
+
+// TestLoadPostgresDatabaseFromEnvDefaults tests that unset optional variables keep the defaults
+func TestLoadPostgresDatabaseFromEnvDefaults(t *testing.T) {
+	t.Setenv("DEFAULTS_DB_HOST", "localhost")
+	t.Setenv("DEFAULTS_DB_NAME", "testdb")
+	t.Setenv("DEFAULTS_DB_USER", "testuser")
+	t.Setenv("DEFAULTS_DB_PORT", "")
+
+	db, err := LoadPostgresDatabaseFromEnv("DEFAULTS_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+
+	want := NewPostgresDatabaseForProfile(ProfileDev, "localhost", 5432, "testdb", "testuser", "")
+	if !reflect.DeepEqual(db, want) {
+		t.Errorf("LoadPostgresDatabaseFromEnv() = %+v, want %+v", *db, *want)
+	}
+}
+
+// TestLoadPostgresDatabaseFromEnvAggregatesErrors tests that every problem is reported at once
+func TestLoadPostgresDatabaseFromEnvAggregatesErrors(t *testing.T) {
+	t.Setenv("BROKEN_DB_HOST", "localhost")
+	t.Setenv("BROKEN_DB_PORT", "not-a-port")
+	t.Setenv("BROKEN_DB_MAX_OPEN_CONNS", "many")
+
+	_, err := LoadPostgresDatabaseFromEnv("BROKEN_DB_")
+	if err == nil {
+		t.Fatal("LoadPostgresDatabaseFromEnv() should fail")
+	}
+
+	var envErr *EnvError
+	if !errors.As(err, &envErr) {
+		t.Fatalf("error should be an *EnvError, got %T", err)
+	}
+
+	wantMissing := []string{"BROKEN_DB_NAME", "BROKEN_DB_USER"}
+	if strings.Join(envErr.Missing, ",") != strings.Join(wantMissing, ",") {
+		t.Errorf("Missing = %v, want %v", envErr.Missing, wantMissing)
+	}
+
+	if len(envErr.Malformed) != 2 {
+		t.Fatalf("Malformed = %v, want 2 entries", envErr.Malformed)
+	}
+	if envErr.Malformed[0].Name != "BROKEN_DB_PORT" || envErr.Malformed[1].Name != "BROKEN_DB_MAX_OPEN_CONNS" {
+		t.Errorf("Malformed names = %s, %s", envErr.Malformed[0].Name, envErr.Malformed[1].Name)
+	}
+
+	msg := err.Error()
+	for _, name := range []string{"BROKEN_DB_NAME", "BROKEN_DB_USER", "BROKEN_DB_PORT", "BROKEN_DB_MAX_OPEN_CONNS"} {
+		if !strings.Contains(msg, name) {
+			t.Errorf("error message %q should mention %s", msg, name)
+		}
+	}
+}
+
+// TestLoadPostgresDatabaseFromEnvLegacyUnits tests that plain integers keep their historical units
+func TestLoadPostgresDatabaseFromEnvLegacyUnits(t *testing.T) {
+	t.Setenv("LEGACY_DB_HOST", "localhost")
+	t.Setenv("LEGACY_DB_NAME", "testdb")
+	t.Setenv("LEGACY_DB_USER", "testuser")
+	t.Setenv("LEGACY_DB_CONN_MAX_LIFETIME", "15")
+	t.Setenv("LEGACY_DB_CONN_MAX_IDLE_TIME", "60")
+	t.Setenv("LEGACY_DB_PING_TIMEOUT", "3")
+
+	db, err := LoadPostgresDatabaseFromEnv("LEGACY_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+
+	if db.MaxConnLifetime != 15*time.Minute {
+		t.Errorf("MaxConnLifetime = %s, want 15m", db.MaxConnLifetime)
+	}
+	if db.MaxConnIdleTime != time.Minute {
+		t.Errorf("MaxConnIdleTime = %s, want 1m", db.MaxConnIdleTime)
+	}
+	if db.EffectiveConnectTimeout() != 3*time.Second {
+		t.Errorf("EffectiveConnectTimeout() = %s, want 3s", db.EffectiveConnectTimeout())
+	}
+}
+
+// TestLoadPostgresDatabaseFromEnvMalformedDuration tests that invalid durations are reported
+func TestLoadPostgresDatabaseFromEnvMalformedDuration(t *testing.T) {
+	t.Setenv("BADDUR_DB_HOST", "localhost")
+	t.Setenv("BADDUR_DB_NAME", "testdb")
+	t.Setenv("BADDUR_DB_USER", "testuser")
+	t.Setenv("BADDUR_DB_CONNECT_TIMEOUT", "10")
+
+	_, err := LoadPostgresDatabaseFromEnv("BADDUR_DB_")
+	var envErr *EnvError
+	if !errors.As(err, &envErr) || len(envErr.Malformed) != 1 || envErr.Malformed[0].Name != "BADDUR_DB_CONNECT_TIMEOUT" {
+		t.Errorf("LoadPostgresDatabaseFromEnv() error = %v, want a malformed BADDUR_DB_CONNECT_TIMEOUT", err)
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// targetSessionAttrs lists the target_session_attrs values accepted by libpq and pgx
+var targetSessionAttrs = map[string]bool{
+	"any":            true,
+	"read-write":     true,
+	"read-only":      true,
+	"primary":        true,
+	"standby":        true,
+	"prefer-standby": true,
+}
+
+// defaultPort is the libpq default port
+const defaultPort = 5432
+
+// HostPort is an additional server of a multi-host configuration.
+// A zero Port stands for the Port of the PostgresDatabase.
+type HostPort struct {
+	Host string
+	Port int
+}
+
+// ParseHostPort parses "host", "host:port", "[ipv6]" or "[ipv6]:port".
+// An unbracketed IPv6 address is read as a host without port.
+func ParseHostPort(s string) (HostPort, error) {
+	s = strings.TrimSpace(s)
+	host, port := s, ""
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return HostPort{}, fmt.Errorf("missing ']' in %q", s)
+		}
+		host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return HostPort{}, fmt.Errorf("unexpected %q after ']' in %q", rest, s)
+			}
+			port = rest[1:]
+		}
+	case strings.Count(s, ":") == 1:
+		host, port, _ = strings.Cut(s, ":")
+	}
+
+	if host == "" {
+		return HostPort{}, fmt.Errorf("empty host in %q", s)
+	}
+	hp := HostPort{Host: host}
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return HostPort{}, fmt.Errorf("invalid port %q", port)
+		}
+		hp.Port = n
+	}
+	return hp, nil
+}
+
+// String formats the host as accepted by ParseHostPort, bracketing IPv6 addresses
+func (h HostPort) String() string {
+	if h.Port == 0 {
+		if strings.Contains(h.Host, ":") {
+			return "[" + h.Host + "]"
+		}
+		return h.Host
+	}
+	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (h HostPort) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseHostPort
+func (h *HostPort) UnmarshalText(text []byte) error {
+	hp, err := ParseHostPort(string(text))
+	if err != nil {
+		return err
+	}
+	*h = hp
+	return nil
+}
+
+// Hosts returns every server to try, in order: Host followed by FallbackHosts, each with
+// its port resolved and brackets removed from IPv6 addresses
+func (p *PostgresDatabase) Hosts() []HostPort {
+	hosts := make([]HostPort, 0, 1+len(p.FallbackHosts))
+	hosts = append(hosts, HostPort{Host: trimBrackets(p.Host), Port: p.Port})
+	for _, fallback := range p.FallbackHosts {
+		port := fallback.Port
+		if port == 0 {
+			port = p.Port
+		}
+		hosts = append(hosts, HostPort{Host: trimBrackets(fallback.Host), Port: port})
+	}
+	return hosts
+}
+
+func trimBrackets(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// validateHosts checks the fallback hosts and target_session_attrs
+func (p *PostgresDatabase) validateHosts(v *validator) {
+	for i, fallback := range p.FallbackHosts {
+		if fallback.Host == "" {
+			v.add("FallbackHosts", "host %d cannot be empty", i+1)
+		}
+		if fallback.Port < 0 || fallback.Port > 65535 {
+			v.add("FallbackHosts", "port of %s must be between 1 and 65535, got %d", fallback.Host, fallback.Port)
+		}
+	}
+	if p.TargetSessionAttrs != "" && !targetSessionAttrs[p.TargetSessionAttrs] {
+		v.add("TargetSessionAttrs",
+			"unknown value %q, expected any, read-write, read-only, primary, standby or prefer-standby",
+			p.TargetSessionAttrs)
+	}
+}
+
+// applyHostList fills Host, Port and FallbackHosts from the comma-separated libpq host
+// and port lists. As with libpq, a single port applies to every host and an empty entry
+// stands for the default port 5432.
+func applyHostList(cfg *PostgresDatabase, hostList, portList string) error {
+	hosts := []string{cfg.Host}
+	if hostList != "" {
+		hosts = strings.Split(hostList, ",")
+	}
+	var ports []string
+	if portList != "" {
+		ports = strings.Split(portList, ",")
+		if len(ports) != 1 && len(ports) != len(hosts) {
+			return fmt.Errorf("invalid port: %d ports given for %d hosts", len(ports), len(hosts))
+		}
+	}
+
+	portAt := func(i int) (int, error) {
+		if len(ports) == 0 {
+			return 0, nil
+		}
+		raw := ports[0]
+		if len(ports) > 1 {
+			raw = ports[i]
+		}
+		if raw == "" {
+			return defaultPort, nil
+		}
+		port, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid port: %w", err)
+		}
+		return port, nil
+	}
+
+	cfg.FallbackHosts = nil
+	for i, host := range hosts {
+		if host == "" {
+			return fmt.Errorf("invalid host: entry %d is empty", i+1)
+		}
+		port, err := portAt(i)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			cfg.Host = host
+			if port != 0 {
+				cfg.Port = port
+			}
+			continue
+		}
+		if port == cfg.Port {
+			port = 0
+		}
+		cfg.FallbackHosts = append(cfg.FallbackHosts, HostPort{Host: host, Port: port})
+	}
+	return nil
+}
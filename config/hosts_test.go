@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TestParseHostPort tests the accepted host[:port] forms
+func TestParseHostPort(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    HostPort
+		wantErr bool
+	}{
+		{in: "standby", want: HostPort{Host: "standby"}},
+		{in: "standby:5433", want: HostPort{Host: "standby", Port: 5433}},
+		{in: "[::1]", want: HostPort{Host: "::1"}},
+		{in: "[::1]:5433", want: HostPort{Host: "::1", Port: 5433}},
+		{in: "fe80::1", want: HostPort{Host: "fe80::1"}},
+		{in: " standby ", want: HostPort{Host: "standby"}},
+		{in: "", wantErr: true},
+		{in: ":5432", wantErr: true},
+		{in: "standby:port", wantErr: true},
+		{in: "standby:70000", wantErr: true},
+		{in: "[::1", wantErr: true},
+		{in: "[::1]5432", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseHostPort(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHostPort(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHostPort(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			if !tt.wantErr {
+				if again, _ := ParseHostPort(got.String()); again != got {
+					t.Errorf("ParseHostPort(%q) = %+v, does not round-trip", got.String(), again)
+				}
+			}
+		})
+	}
+}
+
+// TestMultiHostConnectionString tests that pgx sees every host, port and target_session_attrs
+func TestMultiHostConnectionString(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		fallbacks []HostPort
+		want      []HostPort
+	}{
+		{
+			name:      "host names",
+			host:      "primary",
+			fallbacks: []HostPort{{Host: "standby1", Port: 5433}, {Host: "standby2"}},
+			want:      []HostPort{{"primary", 5432}, {"standby1", 5433}, {"standby2", 5432}},
+		},
+		{
+			name:      "IPv6 addresses",
+			host:      "[2001:db8::1]",
+			fallbacks: []HostPort{{Host: "::1", Port: 5433}, {Host: "standby"}},
+			want:      []HostPort{{"2001:db8::1", 5432}, {"::1", 5433}, {"standby", 5432}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewPostgresDatabase(tt.host, 5432, "mapbot", "admin", "secret")
+			db.FallbackHosts = tt.fallbacks
+			db.TargetSessionAttrs = "read-write"
+
+			for _, connStr := range []string{db.ConnectionString(), db.PublicConnectionString()} {
+				pgCfg, err := pgconn.ParseConfig(connStr)
+				if err != nil {
+					t.Fatalf("pgconn.ParseConfig(%q) error = %v", connStr, err)
+				}
+				got := []HostPort{{pgCfg.Host, int(pgCfg.Port)}}
+				for _, fallback := range pgCfg.Fallbacks {
+					got = append(got, HostPort{fallback.Host, int(fallback.Port)})
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("hosts of %q = %v, want %v", connStr, got, tt.want)
+				}
+				if pgCfg.ValidateConnect == nil {
+					t.Errorf("target_session_attrs of %q was not applied", connStr)
+				}
+			}
+			if strings.Contains(db.PublicConnectionString(), "secret") {
+				t.Errorf("PublicConnectionString() leaks the password")
+			}
+
+			got, err := ParsePostgresDatabase(db.ConnectionString())
+			if err != nil {
+				t.Fatalf("ParsePostgresDatabase() error = %v", err)
+			}
+			want := *db
+			want.Host = tt.want[0].Host
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("round trip = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+// TestParseMultiHost tests host and port lists in both connection string forms
+func TestParseMultiHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		dsn       string
+		host      string
+		port      int
+		fallbacks []HostPort
+		wantErr   bool
+	}{
+		{
+			name:      "URL with per-host ports",
+			dsn:       "postgres://u:p@primary:5433,standby:5434,[::1]/db?target_session_attrs=primary",
+			host:      "primary",
+			port:      5433,
+			fallbacks: []HostPort{{Host: "standby", Port: 5434}, {Host: "::1", Port: 5432}},
+		},
+		{
+			name:      "keyword/value with one port",
+			dsn:       "host=primary,standby port=5433 dbname=db user=u",
+			host:      "primary",
+			port:      5433,
+			fallbacks: []HostPort{{Host: "standby"}},
+		},
+		{
+			name:      "keyword/value with a port list",
+			dsn:       "host=primary,standby port=5433,5434 dbname=db user=u",
+			host:      "primary",
+			port:      5433,
+			fallbacks: []HostPort{{Host: "standby", Port: 5434}},
+		},
+		{name: "port count mismatch", dsn: "host=a,b,c port=1,2 dbname=db", wantErr: true},
+		{name: "empty host entry", dsn: "host=a,,b dbname=db", wantErr: true},
+		{name: "malformed host in URL", dsn: "postgres://u:p@a,[::1/db", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePostgresDatabase(tt.dsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePostgresDatabase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Host != tt.host || got.Port != tt.port || !reflect.DeepEqual(got.FallbackHosts, tt.fallbacks) {
+				t.Errorf("ParsePostgresDatabase() = %s:%d %v, want %s:%d %v",
+					got.Host, got.Port, got.FallbackHosts, tt.host, tt.port, tt.fallbacks)
+			}
+		})
+	}
+}
+
+// TestLoadFallbackHostsFromEnv tests the comma-separated FALLBACK_HOSTS variable
+func TestLoadFallbackHostsFromEnv(t *testing.T) {
+	t.Setenv("MULTI_DB_HOST", "primary")
+	t.Setenv("MULTI_DB_NAME", "mapbot")
+	t.Setenv("MULTI_DB_USER", "admin")
+	t.Setenv("MULTI_DB_FALLBACK_HOSTS", "standby1:5433, [::1]")
+	t.Setenv("MULTI_DB_TARGET_SESSION_ATTRS", "prefer-standby")
+
+	db, err := LoadPostgresDatabaseFromEnv("MULTI_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+	want := []HostPort{{Host: "standby1", Port: 5433}, {Host: "::1"}}
+	if !reflect.DeepEqual(db.FallbackHosts, want) {
+		t.Errorf("FallbackHosts = %v, want %v", db.FallbackHosts, want)
+	}
+	if db.TargetSessionAttrs != "prefer-standby" {
+		t.Errorf("TargetSessionAttrs = %q, want prefer-standby", db.TargetSessionAttrs)
+	}
+
+	t.Setenv("MULTI_DB_FALLBACK_HOSTS", "standby1:port")
+	_, err = LoadPostgresDatabaseFromEnv("MULTI_DB_")
+	var envErr *EnvError
+	if !errors.As(err, &envErr) || len(envErr.Malformed) != 1 || envErr.Malformed[0].Name != "MULTI_DB_FALLBACK_HOSTS" {
+		t.Errorf("LoadPostgresDatabaseFromEnv() error = %v, want a malformed MULTI_DB_FALLBACK_HOSTS", err)
+	}
+}
+
+// TestValidateHosts tests the validation of fallback hosts and target_session_attrs
+func TestValidateHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(db *PostgresDatabase)
+		field  string
+	}{
+		{"empty fallback host", func(db *PostgresDatabase) { db.FallbackHosts = []HostPort{{Port: 5432}} }, "FallbackHosts"},
+		{"fallback port out of range", func(db *PostgresDatabase) {
+			db.FallbackHosts = []HostPort{{Host: "standby", Port: 70000}}
+		}, "FallbackHosts"},
+		{"unknown target_session_attrs", func(db *PostgresDatabase) { db.TargetSessionAttrs = "master" }, "TargetSessionAttrs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewPostgresDatabase("localhost", 5432, "testdb", "user", "pass")
+			tt.modify(db)
+
+			var validationErr *ValidationError
+			if err := db.Validate(); !errors.As(err, &validationErr) || validationErr.Field(tt.field) == nil {
+				t.Errorf("Validate() = %v, want an error on %s", err, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// SettingError describes a value that could not be applied to a setting. The value
+// itself is not kept, since it may be a password.
+type SettingError struct {
+	Key    string // dotted key of the setting, e.g. "database.port"
+	Source string // where the value came from, e.g. "config.yaml" or "env DB_PORT"
+	Err    error
+}
+
+// Error implements the error interface
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("%s from %s: %v", e.Key, e.Source, e.Err)
+}
+
+// Unwrap returns the underlying parse error
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+// LoadError lists every problem found by Load, so that all of them can be fixed at once
+type LoadError struct {
+	Missing   []string // required settings left empty, as "key (ENV_NAME)"
+	Malformed []*SettingError
+}
+
+// Error implements the error interface
+func (e *LoadError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	for _, m := range e.Malformed {
+		parts = append(parts, "malformed "+m.Error())
+	}
+	return "invalid configuration: " + strings.Join(parts, "; ")
+}
+
+func (e *LoadError) empty() bool {
+	return len(e.Missing) == 0 && len(e.Malformed) == 0
+}
+
+// LoadOption configures Load
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	files     []configFile
+	dotEnv    []string
+	envPrefix string
+	overrides map[string]string
+	secrets   *SecretResolver
+	ctx       context.Context
+	profile   Profile
+	sources   Sources
+}
+
+type configFile struct {
+	path     string
+	optional bool
+}
+
+// WithFile reads settings from a YAML (.yaml, .yml), TOML (.toml) or JSON (.json) file.
+// Files are applied in the order they are given; a missing file is an error.
+func WithFile(path string) LoadOption {
+	return func(o *loadOptions) {
+		o.files = append(o.files, configFile{path: path})
+	}
+}
+
+// WithOptionalFile is like WithFile but ignores the file if it does not exist
+func WithOptionalFile(path string) LoadOption {
+	return func(o *loadOptions) {
+		o.files = append(o.files, configFile{path: path, optional: true})
+	}
+}
+
+// WithDotEnv replaces the default ".env" with the given files, the first one taking
+// precedence. Without paths, no .env file is read.
+func WithDotEnv(paths ...string) LoadOption {
+	return func(o *loadOptions) {
+		o.dotEnv = paths
+	}
+}
+
+// WithEnvPrefix prepends prefix to every environment variable name, e.g. "MAPBOT_"
+func WithEnvPrefix(prefix string) LoadOption {
+	return func(o *loadOptions) {
+		o.envPrefix = prefix
+	}
+}
+
+// WithOverrides sets values by dotted key ("database.port": "5433"), above every other
+// source. Overrides typically come from command-line flags.
+func WithOverrides(overrides map[string]string) LoadOption {
+	return func(o *loadOptions) {
+		if o.overrides == nil {
+			o.overrides = make(map[string]string)
+		}
+		for key, value := range overrides {
+			o.overrides[key] = value
+		}
+	}
+}
+
+// WithSecretResolver replaces the default resolver of secret references, typically to
+// register more providers
+func WithSecretResolver(resolver *SecretResolver) LoadOption {
+	return func(o *loadOptions) {
+		o.secrets = resolver
+	}
+}
+
+// WithContext sets the context passed to secret providers (default context.Background)
+func WithContext(ctx context.Context) LoadOption {
+	return func(o *loadOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithProfile selects the profile whose defaults apply, instead of the one named by
+// MAPBOT_ENV
+func WithProfile(profile Profile) LoadOption {
+	return func(o *loadOptions) {
+		o.profile = profile
+	}
+}
+
+// WithSources records in sources where the value of each setting came from, by dotted
+// key, for NewDump. The map is filled as Load runs, so it must not be shared by
+// concurrent loads, such as those of a Watcher.
+func WithSources(sources Sources) LoadOption {
+	return func(o *loadOptions) {
+		o.sources = sources
+	}
+}
+
+// setting is a leaf field reachable from the struct given to Load
+type setting struct {
+	key      string // dotted file key
+	env      string // full environment variable name, empty if none
+	required bool
+	secret   bool
+	def      string // value of the default tag
+	hasDef   bool
+	unit     string
+	tag      reflect.StructTag
+	field    reflect.Value
+}
+
+// defaultFor returns the default of the setting in profile: its default.<profile> tag,
+// or its default tag
+func (s *setting) defaultFor(profile Profile) (string, bool) {
+	if def, ok := s.tag.Lookup("default." + string(profile)); ok {
+		return def, true
+	}
+	return s.def, s.hasDef
+}
+
+// Load fills the struct pointed to by dst from, in increasing order of precedence:
+//
+//  1. defaults: the value already in dst, or for fields left at zero the
+//     default.<profile> tag of the profile (WithProfile, or MAPBOT_ENV from the
+//     environment or .env), or the default tag. The profile is also recorded in the
+//     Profile field of every PostgresDatabase that has none, for Validate.
+//  2. configuration files given by WithFile and WithOptionalFile
+//  3. .env files (".env" in the working directory unless WithDotEnv is used), which
+//     unlike LoadPostgresDatabaseFromEnv are read without modifying the environment
+//  4. environment variables
+//  5. WithOverrides
+//
+// Fields tagged secret:"true", such as PostgresDatabase.Password, may then hold a secret
+// reference (file:///run/secrets/db_password, env://OTHER_VAR, or any scheme registered
+// with WithSecretResolver), which is replaced by the secret it points to.
+//
+// A field takes part if it has an env or config tag. The env tag names its environment
+// variable ("NAME" or "NAME,required"), and the config tag its key in files; when only
+// one is given, the other is derived from it (PORT and port). A struct field, such as a
+// PostgresDatabase, is a section whose settings are nested under its key in files and
+// whose env tag is a prefix of their variables:
+//
+//	type ServiceConfig struct {
+//		Listen   string                  `env:"LISTEN" default:":8080"`
+//		Database *config.PostgresDatabase `env:"DB_" config:"database"`
+//	}
+//
+// Required settings that are still empty after every source are reported, together
+// with every malformed value and unknown file key, in a *LoadError.
+func Load(dst any, opts ...LoadOption) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config.Load needs a non-nil pointer to a struct, got %T", dst)
+	}
+	options := &loadOptions{dotEnv: []string{".env"}, ctx: context.Background()}
+	for _, opt := range opts {
+		opt(options)
+	}
+	dotEnv := make(map[string]string)
+	dotEnvFiles := make(map[string]string)
+	for i := len(options.dotEnv) - 1; i >= 0; i-- {
+		values, err := godotenv.Read(options.dotEnv[i])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", options.dotEnv[i], err)
+		}
+		for name, value := range values {
+			dotEnv[name] = value
+			dotEnvFiles[name] = filepath.Base(options.dotEnv[i])
+		}
+	}
+
+	if options.profile == "" {
+		name, ok := os.LookupEnv(ProfileEnvVar)
+		if !ok || name == "" {
+			name = dotEnv[ProfileEnvVar]
+		}
+		profile, err := ParseProfile(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ProfileEnvVar, err)
+		}
+		options.profile = profile
+	}
+
+	settings := collectSettings(v.Elem(), "", options.envPrefix)
+	setProfile(v.Elem(), options.profile)
+	byKey := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	loadErr := &LoadError{}
+	sources := options.sources
+	if sources == nil {
+		sources = make(Sources)
+	}
+	for _, s := range settings {
+		sources[s.key] = Source{Kind: SourceDefault}
+	}
+	apply := func(s *setting, raw string, source Source) {
+		if err := setField(s.field, raw, s.unit); err != nil {
+			loadErr.Malformed = append(loadErr.Malformed, &SettingError{Key: s.key, Source: source.String(), Err: err})
+			return
+		}
+		sources[s.key] = source
+	}
+
+	for _, s := range settings {
+		if def, ok := s.defaultFor(options.profile); ok && s.field.IsZero() {
+			apply(s, def, Source{Kind: SourceDefault})
+		}
+	}
+
+	for _, file := range options.files {
+		values, err := readConfigFile(file.path)
+		if errors.Is(err, os.ErrNotExist) && file.optional {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		applyFileValues(values, "", byKey, Source{Kind: SourceFile, Name: filepath.Base(file.path)}, apply, loadErr)
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(s.env); ok && raw != "" {
+			apply(s, raw, Source{Kind: SourceEnv, Name: s.env})
+		} else if raw := dotEnv[s.env]; raw != "" {
+			apply(s, raw, Source{Kind: SourceFile, Name: dotEnvFiles[s.env] + " " + s.env})
+		}
+	}
+
+	overrideKeys := make([]string, 0, len(options.overrides))
+	for key := range options.overrides {
+		overrideKeys = append(overrideKeys, key)
+	}
+	sort.Strings(overrideKeys)
+	for _, key := range overrideKeys {
+		s, ok := byKey[key]
+		if !ok {
+			loadErr.Malformed = append(loadErr.Malformed,
+				&SettingError{Key: key, Source: "override", Err: errors.New("unknown key")})
+			continue
+		}
+		apply(s, options.overrides[key], Source{Kind: SourceOverride})
+	}
+
+	secrets := options.secrets
+	if secrets == nil {
+		secrets = NewSecretResolver()
+	}
+	for _, s := range settings {
+		secrets.resolveSetting(options.ctx, s, loadErr)
+	}
+
+	for _, s := range settings {
+		if s.required && s.field.IsZero() {
+			missing := s.key
+			if s.env != "" {
+				missing += " (" + s.env + ")"
+			}
+			loadErr.Missing = append(loadErr.Missing, missing)
+		}
+	}
+
+	if loadErr.empty() {
+		return nil
+	}
+	return loadErr
+}
+
+// collectSettings lists the tagged leaf fields of v, descending into sections.
+// Nil pointers to sections are allocated.
+func collectSettings(v reflect.Value, keyPrefix, envPrefix string) []*setting {
+	var settings []*setting
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		envTag, hasEnv := sf.Tag.Lookup("env")
+		key, hasKey := sf.Tag.Lookup("config")
+		if (!hasEnv && !hasKey) || key == "-" || !sf.IsExported() {
+			continue
+		}
+		envName, envOpts, _ := strings.Cut(envTag, ",")
+		if key == "" {
+			key = strings.ToLower(strings.Trim(envName, "_"))
+		}
+		if !hasEnv {
+			envName = strings.ToUpper(key)
+		}
+
+		field := v.Field(i)
+		if isSection(field) {
+			if field.Kind() == reflect.Pointer {
+				if field.IsNil() {
+					field.Set(reflect.New(field.Type().Elem()))
+				}
+				field = field.Elem()
+			}
+			if !hasEnv {
+				envName += "_"
+			}
+			if envName == "-" {
+				envName = ""
+			}
+			settings = append(settings, collectSettings(field, keyPrefix+key+".", envPrefix+envName)...)
+			continue
+		}
+
+		s := &setting{
+			key:      keyPrefix + key,
+			required: envOpts == "required",
+			unit:     sf.Tag.Get("unit"),
+			field:    field,
+		}
+		if envName != "-" {
+			s.env = envPrefix + envName
+		}
+		s.def, s.hasDef = sf.Tag.Lookup("default")
+		s.tag = sf.Tag
+		s.secret = sf.Tag.Get("secret") == "true"
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// isSection reports whether field is a nested struct rather than a value parsed from text
+func isSection(field reflect.Value) bool {
+	t := field.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem())
+}
+
+// readConfigFile decodes a configuration file into nested maps, by extension
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path chosen by the application
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file format %q, expected .yaml, .yml, .toml or .json", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyFileValues applies the nested values of a file, reporting unknown keys
+func applyFileValues(values map[string]any, keyPrefix string, byKey map[string]*setting, source Source,
+	apply func(*setting, string, Source), loadErr *LoadError) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		fullKey := keyPrefix + key
+		if value == nil {
+			continue
+		}
+		if s, ok := byKey[fullKey]; ok {
+			apply(s, formatValue(value), source)
+			continue
+		}
+		if section, ok := value.(map[string]any); ok {
+			applyFileValues(section, fullKey+".", byKey, source, apply, loadErr)
+			continue
+		}
+		loadErr.Malformed = append(loadErr.Malformed,
+			&SettingError{Key: fullKey, Source: source.String(), Err: errors.New("unknown key")})
+	}
+}
+
+// formatValue renders a decoded file value in the text form parsed by setField:
+// lists are comma-separated and maps are comma-separated key=value pairs
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case []any:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + "=" + formatValue(value[key])
+		}
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
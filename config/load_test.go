@@ -0,0 +1,231 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serviceConfig is a typical service configuration with a database section
+type serviceConfig struct {
+	Listen   string            `env:"LISTEN" default:":8080"`
+	Workers  int               `config:"workers" default:"4"`
+	Debug    bool              `env:"DEBUG"`
+	Timeout  time.Duration     `env:"TIMEOUT" default:"30s"`
+	Database *PostgresDatabase `env:"DB_" config:"database"`
+	Internal string
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// TestLoadPrecedence tests that each source overrides the previous ones
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "service.yaml", `
+listen: ":9000"
+workers: 8
+database:
+  host: db.example.com
+  name: mapbot
+  user: mapbot
+  port: 5433
+  sslmode: prefer
+`)
+	dotEnv := writeConfigFile(t, ".env", "PREC_LISTEN=:9001\nPREC_DB_PORT=5434\nPREC_DB_SSLMODE=require\n")
+	t.Setenv("PREC_LISTEN", ":9002")
+	t.Setenv("PREC_DB_PORT", "5435")
+
+	var cfg serviceConfig
+	err := Load(&cfg,
+		WithFile(file),
+		WithDotEnv(dotEnv),
+		WithEnvPrefix("PREC_"),
+		WithOverrides(map[string]string{"database.port": "5436"}),
+	)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"timeout (default)", cfg.Timeout, 30 * time.Second},
+		{"workers (file)", cfg.Workers, 8},
+		{"database.sslmode (.env)", cfg.Database.SSLMode, "require"},
+		{"listen (environment)", cfg.Listen, ":9002"},
+		{"database.port (override)", cfg.Database.Port, 5436},
+		{"database.max_open_conns (default)", cfg.Database.MaxOpenConns, 25},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if _, ok := os.LookupEnv("PREC_DB_SSLMODE"); ok {
+		t.Error("Load() should not modify the environment with .env values")
+	}
+}
+
+// TestLoadFileFormats tests that YAML, TOML and JSON files load the same configuration
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"service.yaml": `
+listen: ":9000"
+debug: true
+timeout: 1m
+database:
+  host: primary
+  name: mapbot
+  user: mapbot
+  fallback_hosts: ["standby1:5433", "standby2"]
+  conn_max_lifetime: 15
+`,
+		"service.toml": `
+listen = ":9000"
+debug = true
+timeout = "1m"
+
+[database]
+host = "primary"
+name = "mapbot"
+user = "mapbot"
+fallback_hosts = ["standby1:5433", "standby2"]
+conn_max_lifetime = 15
+`,
+		"service.json": `{
+  "listen": ":9000",
+  "debug": true,
+  "timeout": "1m",
+  "database": {
+    "host": "primary",
+    "name": "mapbot",
+    "user": "mapbot",
+    "fallback_hosts": ["standby1:5433", "standby2"],
+    "conn_max_lifetime": 15
+  }
+}`,
+	}
+
+	want := serviceConfig{
+		Listen:   ":9000",
+		Workers:  4,
+		Debug:    true,
+		Timeout:  time.Minute,
+		Database: NewPostgresDatabaseForProfile(ProfileDev, "primary", 5432, "mapbot", "mapbot", ""),
+	}
+	want.Database.FallbackHosts = []HostPort{{Host: "standby1", Port: 5433}, {Host: "standby2"}}
+	want.Database.MaxConnLifetime = 15 * time.Minute
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			var cfg serviceConfig
+			if err := Load(&cfg, WithFile(writeConfigFile(t, name, content)), WithDotEnv(), WithEnvPrefix("FORMATS_")); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("Load() = %+v\ndatabase %+v\nwant %+v\ndatabase %+v", cfg, *cfg.Database, want, *want.Database)
+			}
+		})
+	}
+}
+
+// TestLoadReportsEveryProblem tests that unknown keys, malformed and missing values are aggregated
+func TestLoadReportsEveryProblem(t *testing.T) {
+	file := writeConfigFile(t, "service.yaml", `
+workers: many
+databse:
+  host: typo
+database:
+  host: localhost
+  password: hunter2
+  port: hunter2
+`)
+	t.Setenv("PROBLEMS_DEBUG", "maybe")
+
+	var cfg serviceConfig
+	err := Load(&cfg,
+		WithFile(file),
+		WithDotEnv(),
+		WithEnvPrefix("PROBLEMS_"),
+		WithOverrides(map[string]string{"database.nope": "1"}),
+	)
+
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("Load() error = %v, want a *LoadError", err)
+	}
+
+	wantMissing := []string{"database.name (PROBLEMS_DB_NAME)", "database.user (PROBLEMS_DB_USER)"}
+	if !reflect.DeepEqual(loadErr.Missing, wantMissing) {
+		t.Errorf("Missing = %v, want %v", loadErr.Missing, wantMissing)
+	}
+
+	var malformed []string
+	for _, m := range loadErr.Malformed {
+		malformed = append(malformed, m.Key+" from "+m.Source)
+	}
+	wantMalformed := []string{
+		"database.port from service.yaml",
+		"databse.host from service.yaml",
+		"workers from service.yaml",
+		"debug from env PROBLEMS_DEBUG",
+		"database.nope from override",
+	}
+	if !reflect.DeepEqual(malformed, wantMalformed) {
+		t.Errorf("Malformed = %v, want %v", malformed, wantMalformed)
+	}
+
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("error %q leaks a value", err)
+	}
+}
+
+// TestLoadFiles tests missing, optional and unsupported files
+func TestLoadFiles(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	overrides := WithOverrides(map[string]string{"database.host": "h", "database.name": "n", "database.user": "u"})
+
+	var cfg serviceConfig
+	if err := Load(&cfg, WithFile(missing), WithDotEnv(), overrides); err == nil {
+		t.Error("Load() with a missing file should fail")
+	}
+	if err := Load(&cfg, WithOptionalFile(missing), WithDotEnv(), overrides); err != nil {
+		t.Errorf("Load() with a missing optional file error = %v", err)
+	}
+	if err := Load(&cfg, WithFile(writeConfigFile(t, "service.ini", "listen=:80")), WithDotEnv(), overrides); err == nil {
+		t.Error("Load() with an .ini file should fail")
+	}
+	if err := Load(&cfg, WithFile(writeConfigFile(t, "service.json", "{")), WithDotEnv(), overrides); err == nil {
+		t.Error("Load() with invalid JSON should fail")
+	}
+	if err := Load(cfg); err == nil {
+		t.Error("Load() with a non-pointer should fail")
+	}
+}
+
+// TestLoadPostgresDatabaseDefaults tests that the default tags match NewPostgresDatabase
+func TestLoadPostgresDatabaseDefaults(t *testing.T) {
+	var db PostgresDatabase
+	err := Load(&db, WithDotEnv(), WithEnvPrefix("DEFAULT_TAGS_"),
+		WithOverrides(map[string]string{"host": "localhost", "name": "testdb", "user": "testuser"}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := NewPostgresDatabase("localhost", 5432, "testdb", "testuser", "")
+	want.Profile = ProfileDev
+	if !reflect.DeepEqual(&db, want) {
+		t.Errorf("Load() = %+v, want %+v", db, *want)
+	}
+}
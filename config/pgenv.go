@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+)
+
+// pgEnvKeywords maps the libpq environment variables to the connection keywords they
+// default, as understood by ParsePostgresDatabase
+var pgEnvKeywords = map[string]string{
+	"PGHOST":                  "host",
+	"PGPORT":                  "port",
+	"PGDATABASE":              "dbname",
+	"PGUSER":                  "user",
+	"PGPASSWORD":              "password",
+	"PGAPPNAME":               "application_name",
+	"PGSSLMODE":               "sslmode",
+	"PGSSLROOTCERT":           "sslrootcert",
+	"PGSSLCERT":               "sslcert",
+	"PGSSLKEY":                "sslkey",
+	"PGSSLMINPROTOCOLVERSION": "ssl_min_protocol_version",
+	"PGCONNECT_TIMEOUT":       "connect_timeout",
+	"PGTARGETSESSIONATTRS":    "target_session_attrs",
+	"PGOPTIONS":               "options",
+}
+
+// pgEnvRuntimeParams maps the libpq environment variables of server settings to the
+// RuntimeParams they default
+var pgEnvRuntimeParams = map[string]string{
+	"PGTZ":        "TimeZone",
+	"PGDATESTYLE": "DateStyle",
+	"PGGEQO":      "geqo",
+}
+
+// LoadPostgresDatabaseFromPGEnv builds a PostgresDatabase from the libpq environment
+// variables, so that the environment of psql also works for services:
+//
+//	PGHOST, PGHOSTADDR       Host and FallbackHosts (comma-separated lists)
+//	PGPORT                   Port (a list matching PGHOST)
+//	PGDATABASE               Database, the user name by default
+//	PGUSER                   User, the operating system user by default
+//	PGPASSWORD               Password
+//	PGPASSFILE               password file used when PGPASSWORD is not set, see ApplyPgpass
+//	PGSERVICE                service whose settings take precedence, see LoadPostgresDatabaseFromService
+//	PGSERVICEFILE            service file, see ServiceFiles
+//	PGAPPNAME                ApplicationName
+//	PGSSLMODE, PGREQUIRESSL  SSLMode (PGREQUIRESSL=1 stands for require)
+//	PGSSLROOTCERT            SSLRootCert
+//	PGSSLCERT, PGSSLKEY      SSLCert, SSLKey
+//	PGSSLMINPROTOCOLVERSION  SSLMinProtocolVersion
+//	PGCONNECT_TIMEOUT        ConnectTimeout (seconds)
+//	PGTARGETSESSIONATTRS     TargetSessionAttrs
+//	PGOPTIONS                RuntimeParams, from its "-c name=value" settings
+//	PGTZ, PGDATESTYLE        RuntimeParams TimeZone and DateStyle
+//	PGGEQO                   RuntimeParams geqo
+//
+// PGHOSTADDR is used when PGHOST is not set. As with libpq, empty variables are
+// ignored; unlike libpq, the default host is localhost rather than a Unix socket. Other
+// settings keep the defaults of the profile named by MAPBOT_ENV, see
+// NewPostgresDatabaseForProfile.
+func LoadPostgresDatabaseFromPGEnv() (*PostgresDatabase, error) {
+	settings := make(map[string]string)
+	for name, keyword := range pgEnvKeywords {
+		if value := os.Getenv(name); value != "" {
+			settings[keyword] = value
+		}
+	}
+	if _, ok := settings["host"]; !ok {
+		if hostaddr := os.Getenv("PGHOSTADDR"); hostaddr != "" {
+			settings["host"] = hostaddr
+		}
+	}
+	if _, ok := settings["sslmode"]; !ok && os.Getenv("PGREQUIRESSL") == "1" {
+		settings["sslmode"] = "require"
+	}
+	if name := os.Getenv("PGSERVICE"); name != "" {
+		service, err := lookupService(name)
+		if err != nil {
+			return nil, err
+		}
+		for keyword, value := range service {
+			settings[keyword] = value
+		}
+	}
+
+	profile, err := ProfileFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg := NewPostgresDatabaseForProfile(profile, "localhost", 5432, "", "", "")
+	if err := applySettings(cfg, settings); err != nil {
+		return nil, fmt.Errorf("invalid PG environment variables: %w", err)
+	}
+	for name, param := range pgEnvRuntimeParams {
+		if value := os.Getenv(name); value != "" {
+			if _, ok := cfg.RuntimeParams[param]; !ok {
+				if cfg.RuntimeParams == nil {
+					cfg.RuntimeParams = make(map[string]string)
+				}
+				cfg.RuntimeParams[param] = value
+			}
+		}
+	}
+	if cfg.User == "" {
+		if current, err := user.Current(); err == nil {
+			cfg.User = current.Username
+		}
+	}
+	if cfg.Database == "" {
+		cfg.Database = cfg.User
+	}
+	if err := cfg.ApplyPgpass(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// clearPGEnv empties every libpq variable for the duration of the test
+func clearPGEnv(t *testing.T) {
+	t.Helper()
+	for name := range pgEnvKeywords {
+		t.Setenv(name, "")
+	}
+	for name := range pgEnvRuntimeParams {
+		t.Setenv(name, "")
+	}
+	for _, name := range []string{"PGHOSTADDR", "PGREQUIRESSL", "PGSERVICE", "PGSERVICEFILE", "PGSYSCONFDIR"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("PGPASSFILE", t.TempDir()+"/missing")
+}
+
+// TestLoadPostgresDatabaseFromPGEnv tests the mapping of the libpq variables
+func TestLoadPostgresDatabaseFromPGEnv(t *testing.T) {
+	clearPGEnv(t)
+	t.Setenv("PGHOST", "db1.example.com,db2.example.com")
+	t.Setenv("PGPORT", "5433,5434")
+	t.Setenv("PGDATABASE", "mapbot")
+	t.Setenv("PGUSER", "admin")
+	t.Setenv("PGPASSWORD", "secret")
+	t.Setenv("PGAPPNAME", "mapbot-importer")
+	t.Setenv("PGSSLMODE", "verify-full")
+	t.Setenv("PGSSLROOTCERT", "/etc/ssl/root.crt")
+	t.Setenv("PGSSLMINPROTOCOLVERSION", "TLSv1.3")
+	t.Setenv("PGCONNECT_TIMEOUT", "3")
+	t.Setenv("PGTARGETSESSIONATTRS", "read-write")
+	t.Setenv("PGREQUIRESSL", "1")
+	t.Setenv("PGOPTIONS", "-c search_path=admin,public -c work_mem=64MB")
+	t.Setenv("PGTZ", "Europe/Paris")
+
+	cfg, err := LoadPostgresDatabaseFromPGEnv()
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromPGEnv() error = %v", err)
+	}
+	want := NewPostgresDatabaseForProfile(ProfileDev, "db1.example.com", 5433, "mapbot", "admin", "secret")
+	want.FallbackHosts = []HostPort{{Host: "db2.example.com", Port: 5434}}
+	want.ApplicationName = "mapbot-importer"
+	want.SSLMode = "verify-full"
+	want.SSLRootCert = "/etc/ssl/root.crt"
+	want.SSLMinProtocolVersion = "TLSv1.3"
+	want.ConnectTimeout = 3 * time.Second
+	want.TargetSessionAttrs = "read-write"
+	want.SearchPath = "admin,public"
+	want.RuntimeParams = map[string]string{"work_mem": "64MB", "TimeZone": "Europe/Paris"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadPostgresDatabaseFromPGEnv() = %+v, want %+v", *cfg, *want)
+	}
+}
+
+// TestLoadPostgresDatabaseFromPGEnvDefaults tests the libpq defaults and fallbacks
+func TestLoadPostgresDatabaseFromPGEnvDefaults(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	clearPGEnv(t)
+	t.Setenv("PGHOSTADDR", "10.0.0.5")
+	t.Setenv("PGREQUIRESSL", "1")
+
+	cfg, err := LoadPostgresDatabaseFromPGEnv()
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromPGEnv() error = %v", err)
+	}
+	if cfg.Host != "10.0.0.5" || cfg.Port != 5432 || cfg.SSLMode != "require" {
+		t.Errorf("connection = %s:%d sslmode=%s, want 10.0.0.5:5432 sslmode=require", cfg.Host, cfg.Port, cfg.SSLMode)
+	}
+	if cfg.User != current.Username || cfg.Database != current.Username {
+		t.Errorf("User = %q, Database = %q, want the user name %q", cfg.User, cfg.Database, current.Username)
+	}
+}
+
+// TestLoadPostgresDatabaseFromPGEnvService tests that service settings take precedence
+// and that the password file fills a missing password
+func TestLoadPostgresDatabaseFromPGEnvService(t *testing.T) {
+	clearPGEnv(t)
+	t.Setenv("PGSERVICEFILE", writeConfigFile(t, "pg_service.conf", testServiceFile))
+	t.Setenv("PGPASSFILE", writeConfigFile(t, "pgpass", "*:*:*:admin:from-pgpass\n"))
+	t.Setenv("PGSERVICE", "mapbot")
+	t.Setenv("PGHOST", "ignored.example.com")
+	t.Setenv("PGAPPNAME", "psql")
+
+	cfg, err := LoadPostgresDatabaseFromPGEnv()
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromPGEnv() error = %v", err)
+	}
+	if cfg.Host != "db.example.com" || cfg.ApplicationName != "psql" || cfg.Password != "from-pgpass" {
+		t.Errorf("LoadPostgresDatabaseFromPGEnv() = %+v", *cfg)
+	}
+
+	t.Setenv("PGPORT", "not-a-port")
+	t.Setenv("PGSERVICE", "")
+	if _, err := LoadPostgresDatabaseFromPGEnv(); err == nil {
+		t.Error("LoadPostgresDatabaseFromPGEnv() with an invalid PGPORT should fail")
+	}
+}
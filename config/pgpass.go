@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// PgpassFile returns the path of the libpq password file: PGPASSFILE if set, otherwise
+// ~/.pgpass (%APPDATA%\postgresql\pgpass.conf on Windows)
+func PgpassFile() string {
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "postgresql", "pgpass.conf")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// ApplyPgpass fills an empty Password from the password file (see PgpassFile), as libpq
+// does. The first line matching Host, Port, Database and User is used, "*" matching
+// anything; a Unix socket directory or an empty Host matches "localhost". With
+// FallbackHosts, the entry of Host is used.
+//
+// A missing password file is not an error. Unlike libpq, which only prints a warning, a
+// password file readable by group or others is reported as an error (except on Windows).
+func (p *PostgresDatabase) ApplyPgpass() error {
+	if p.Password != "" {
+		return nil
+	}
+	path := PgpassFile()
+	if path == "" {
+		return nil
+	}
+	password, err := lookupPgpass(path, p.pgpassHost(), strconv.Itoa(p.portOrDefault()), p.Database, p.User)
+	if err != nil {
+		return err
+	}
+	p.Password = password
+	return nil
+}
+
+// pgpassHost returns the host name matched in the password file
+func (p *PostgresDatabase) pgpassHost() string {
+	if p.Host == "" || strings.HasPrefix(p.Host, "/") {
+		return "localhost"
+	}
+	return strings.Trim(p.Host, "[]")
+}
+
+func (p *PostgresDatabase) portOrDefault() int {
+	if p.Port == 0 {
+		return defaultPort
+	}
+	return p.Port
+}
+
+// lookupPgpass returns the password of the first entry of the file at path matching the
+// connection, or an empty string
+func lookupPgpass(path, host, port, database, user string) (string, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("password file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("password file %s is not a plain file", path)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("password file %s has group or world access; permissions should be u=rw (0600) or less", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("password file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := [4]string{host, port, database, user}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) < 5 {
+			continue
+		}
+		matches := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("password file: %w", err)
+	}
+	return "", nil
+}
+
+// splitPgpassLine splits a hostname:port:database:username:password line. A backslash
+// escapes ':' and '\'; the password is the rest of the line.
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String())
+}
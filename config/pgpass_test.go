@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testPgpass = `# hostname:port:database:username:password
+db.example.com:5432:mapbot:admin:exact
+db.example.com:*:*:admin:any-database
+localhost:5432:mapbot:admin:local
+*:*:*:reader:pass\:with\\escapes
+incomplete:line
+`
+
+// TestApplyPgpass tests that the first matching entry fills an empty password
+func TestApplyPgpass(t *testing.T) {
+	t.Setenv("PGPASSFILE", writeConfigFile(t, "pgpass", testPgpass))
+
+	tests := []struct {
+		name string
+		cfg  *PostgresDatabase
+		want string
+	}{
+		{"exact match", NewPostgresDatabase("db.example.com", 5432, "mapbot", "admin", ""), "exact"},
+		{"wildcards", NewPostgresDatabase("db.example.com", 5433, "other", "admin", ""), "any-database"},
+		{"unix socket", NewPostgresDatabase("/var/run/postgresql", 5432, "mapbot", "admin", ""), "local"},
+		{"escapes", NewPostgresDatabase("anywhere", 5432, "mapbot", "reader", ""), `pass:with\escapes`},
+		{"no match", NewPostgresDatabase("other.example.com", 5432, "mapbot", "admin", ""), ""},
+		{"password set", NewPostgresDatabase("db.example.com", 5432, "mapbot", "admin", "given"), "given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.ApplyPgpass(); err != nil {
+				t.Fatalf("ApplyPgpass() error = %v", err)
+			}
+			if tt.cfg.Password != tt.want {
+				t.Errorf("Password = %q, want %q", tt.cfg.Password, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyPgpassFileChecks tests missing files and the permission check
+func TestApplyPgpassFileChecks(t *testing.T) {
+	cfg := NewPostgresDatabase("db.example.com", 5432, "mapbot", "admin", "")
+
+	t.Setenv("PGPASSFILE", t.TempDir()+"/missing")
+	if err := cfg.ApplyPgpass(); err != nil || cfg.Password != "" {
+		t.Errorf("ApplyPgpass() with a missing file = %v, password %q", err, cfg.Password)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+	path := writeConfigFile(t, "pgpass", testPgpass)
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGPASSFILE", path)
+	err := cfg.ApplyPgpass()
+	if err == nil || !strings.Contains(err.Error(), "group or world access") {
+		t.Errorf("ApplyPgpass() with a world-readable file error = %v", err)
+	}
+	if cfg.Password != "" {
+		t.Error("a world-readable password file should not be used")
+	}
+}
@@ -1,20 +1,75 @@
 package config
 
-import "fmt"
+import (
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
 
-// PostgresDatabase configuration structure for PostgreSQL connections
+// PostgresDatabase configuration structure for PostgreSQL connections.
+// The env tags name the environment variables read by LoadPostgresDatabaseFromEnv and
+// Load, and the default tags repeat the defaults of NewPostgresDatabase for Load. The
+// default.<profile> tags override them in that profile, for Load and
+// NewPostgresDatabaseForProfile alike. The desc tags describe the settings in the
+// reference generated by NewReference.
+// Fields tagged secret may hold a secret reference (see SecretResolver) and are never
+// printed: String and LogValue show PublicConnectionString.
 type PostgresDatabase struct {
-	Host            string
-	Port            int
-	Database        string
-	User            string
-	Password        string
-	SSLMode         string
-	MaxOpenConns    int
-	MaxIdleConns    int
-	ConnMaxLifetime int // in minutes
-	ConnMaxIdleTime int // in seconds
-	PingTimeout     int // in seconds
+	Host     string `env:"HOST,required" desc:"Server host name, IP address or Unix socket directory"`
+	Port     int    `env:"PORT" default:"5432" desc:"Server port"`
+	Database string `env:"NAME,required" desc:"Database name"`
+	User     string `env:"USER,required" desc:"User name"`
+	Password string `env:"PASSWORD" secret:"true" desc:"Password, or a secret reference such as file:///run/secrets/db_password"`
+	SSLMode  string `env:"SSLMODE" default:"disable" default.staging:"require" default.prod:"require" desc:"disable, allow, prefer, require, verify-ca or verify-full"`
+
+	// Session settings, sent by each new connection. Zero values keep the server
+	// defaults. RuntimeParams holds any other server setting, such as work_mem or
+	// TimeZone.
+	ApplicationName  string            `env:"APPLICATION_NAME" desc:"Name shown in pg_stat_activity and the server logs"`
+	SearchPath       string            `env:"SEARCH_PATH" desc:"Schema search path, such as admin,public,postgis"`
+	StatementTimeout time.Duration     `env:"STATEMENT_TIMEOUT" unit:"ms" desc:"Maximum duration of a statement"`
+	LockTimeout      time.Duration     `env:"LOCK_TIMEOUT" unit:"ms" desc:"Maximum wait for a lock"`
+	RuntimeParams    map[string]string `env:"RUNTIME_PARAMS" desc:"Other server settings, such as work_mem=64MB,jit=off"`
+
+	// Multi-host failover: the hosts are tried in order, Host first, until one accepts
+	// the connection and matches TargetSessionAttrs.
+	FallbackHosts      []HostPort `env:"FALLBACK_HOSTS" desc:"Hosts tried after the first one, such as standby1:5433,standby2"`
+	TargetSessionAttrs string     `env:"TARGET_SESSION_ATTRS" desc:"any, read-write, read-only, primary, standby or prefer-standby"`
+
+	// TLS settings, see TLSConfig. Certificates and key are read either from a file
+	// path or from in-memory PEM, never both.
+	SSLRootCert           string `env:"SSLROOTCERT" desc:"CA certificates file path, or system"`
+	SSLRootCertPEM        string `env:"SSLROOTCERT_PEM" desc:"PEM-encoded CA certificates"`
+	SSLCert               string `env:"SSLCERT" desc:"Client certificate file path"`
+	SSLCertPEM            string `env:"SSLCERT_PEM" desc:"PEM-encoded client certificate"`
+	SSLKey                string `env:"SSLKEY" desc:"Client key file path"`
+	SSLKeyPEM             string `env:"SSLKEY_PEM" secret:"true" desc:"PEM-encoded client key"`
+	SSLServerName         string `env:"SSL_SERVER_NAME" desc:"Server name for SNI and verify-full, instead of the host"`
+	SSLMinProtocolVersion string `env:"SSL_MIN_PROTOCOL_VERSION" desc:"TLSv1, TLSv1.1, TLSv1.2 or TLSv1.3"`
+
+	MaxOpenConns int `env:"MAX_OPEN_CONNS" default:"25" default.test:"5" default.prod:"50" desc:"Maximum connections of each pool"`
+	MaxIdleConns int `env:"MAX_IDLE_CONNS" default:"5" default.test:"2" default.prod:"10" desc:"Idle connections kept by each pool"`
+
+	// Timeouts, written as "90s" or "5m" in the environment. A plain integer is read in
+	// the unit of the deprecated field the timeout replaces.
+	MaxConnLifetime time.Duration `env:"CONN_MAX_LIFETIME" unit:"m" default:"5m" desc:"Maximum age of a connection"`
+	MaxConnIdleTime time.Duration `env:"CONN_MAX_IDLE_TIME" unit:"s" default:"30s" desc:"Maximum idle time of a connection"`
+	ConnectTimeout  time.Duration `env:"CONNECT_TIMEOUT" default:"10s" desc:"Bounds each connection attempt and the startup ping"`
+
+	// Deprecated: use MaxConnLifetime. When non-zero, takes precedence (in minutes).
+	ConnMaxLifetime int
+	// Deprecated: use MaxConnIdleTime. When non-zero, takes precedence (in seconds).
+	ConnMaxIdleTime int
+	// Deprecated: use ConnectTimeout. When non-zero, takes precedence (in seconds).
+	PingTimeout int `env:"PING_TIMEOUT" desc:"Deprecated: use CONNECT_TIMEOUT (seconds)"`
+
+	// Profile is the profile whose rules Validate applies, ProfileDev if empty. Load, the
+	// environment loaders and NewPostgresDatabaseForProfile set it to the profile whose
+	// defaults they used.
+	Profile Profile
 }
 
 // NewPostgresDatabase creates a PostgresDatabase config with sensible defaults
@@ -28,20 +83,145 @@ func NewPostgresDatabase(host string, port int, database, user, password string)
 		SSLMode:         "disable",
 		MaxOpenConns:    25,
 		MaxIdleConns:    5,
-		ConnMaxLifetime: 5,
-		ConnMaxIdleTime: 30,
-		PingTimeout:     10,
+		MaxConnLifetime: 5 * time.Minute,
+		MaxConnIdleTime: 30 * time.Second,
+		ConnectTimeout:  10 * time.Second,
+	}
+}
+
+// EffectiveMaxConnLifetime returns the deprecated ConnMaxLifetime if set, MaxConnLifetime otherwise
+func (p *PostgresDatabase) EffectiveMaxConnLifetime() time.Duration {
+	if p.ConnMaxLifetime != 0 {
+		return time.Duration(p.ConnMaxLifetime) * time.Minute
+	}
+	return p.MaxConnLifetime
+}
+
+// EffectiveMaxConnIdleTime returns the deprecated ConnMaxIdleTime if set, MaxConnIdleTime otherwise
+func (p *PostgresDatabase) EffectiveMaxConnIdleTime() time.Duration {
+	if p.ConnMaxIdleTime != 0 {
+		return time.Duration(p.ConnMaxIdleTime) * time.Second
+	}
+	return p.MaxConnIdleTime
+}
+
+// EffectiveConnectTimeout returns the deprecated PingTimeout if set, ConnectTimeout otherwise
+func (p *PostgresDatabase) EffectiveConnectTimeout() time.Duration {
+	if p.PingTimeout != 0 {
+		return time.Duration(p.PingTimeout) * time.Second
 	}
+	return p.ConnectTimeout
 }
 
-// ConnectionString returns the PostgreSQL connection string
+// ConnectionString returns the PostgreSQL connection string.
+// User, password and database name are percent-encoded, so they may contain reserved
+// characters such as '@', '/', '#' or '?'. Pool settings that are set are encoded as
+// pgxpool parameters (see ParsePostgresDatabase), and certificate file paths and
+// SSLMinProtocolVersion as their libpq parameters. Session settings are sent as runtime
+// parameters, RuntimeParams through the libpq options parameter ("-c name=value").
+// In-memory PEM, SSLServerName and SSLMinProtocolVersion have no pgx equivalent and are
+// applied by database.NewManager through TLSConfig.
+//
+// With FallbackHosts, the URL lists every host with its port ("h1:5432,h2:5433"), or
+// uses the libpq host and port parameters when one of them is an IPv6 address, since
+// bracketed addresses are not accepted in a host list by Go URL parsing.
 func (p *PostgresDatabase) ConnectionString() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		p.User, p.Password, p.Host, p.Port, p.Database, p.SSLMode)
+	return p.connectionURL(url.UserPassword(p.User, p.Password).String())
 }
 
 // PublicConnectionString returns the connection string without password for logging
 func (p *PostgresDatabase) PublicConnectionString() string {
-	return fmt.Sprintf("postgres://%s:****@%s:%d/%s?sslmode=%s",
-		p.User, p.Host, p.Port, p.Database, p.SSLMode)
+	return p.connectionURL(url.User(p.User).String() + ":****")
+}
+
+// String returns PublicConnectionString, so that printing a configuration never shows
+// secrets. It has a value receiver to cover printing a PostgresDatabase as well as a pointer.
+func (p PostgresDatabase) String() string {
+	return p.PublicConnectionString()
+}
+
+// LogValue implements slog.LogValuer with PublicConnectionString, for values and pointers
+func (p PostgresDatabase) LogValue() slog.Value {
+	return slog.StringValue(p.PublicConnectionString())
+}
+
+// connectionURL assembles the URL around an already encoded userinfo. IPv6 hosts are
+// bracketed, whether or not the brackets were given in Host.
+func (p *PostgresDatabase) connectionURL(userinfo string) string {
+	hosts := p.Hosts()
+	authority := make([]string, len(hosts))
+	hasIPv6 := false
+	for i, h := range hosts {
+		authority[i] = net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+		hasIPv6 = hasIPv6 || strings.Contains(h.Host, ":")
+	}
+
+	query := p.queryString()
+	if len(hosts) > 1 && hasIPv6 {
+		names := make([]string, len(hosts))
+		ports := make([]string, len(hosts))
+		for i, h := range hosts {
+			names[i] = h.Host
+			ports[i] = strconv.Itoa(h.Port)
+		}
+		authority = nil
+		query = "host=" + url.QueryEscape(strings.Join(names, ",")) +
+			"&port=" + strings.Join(ports, ",") + "&" + query
+	}
+
+	return "postgres://" + userinfo + "@" + strings.Join(authority, ",") +
+		"/" + url.PathEscape(p.Database) + "?" + query
+}
+
+// queryString encodes sslmode, certificate paths, ssl_min_protocol_version, target_session_attrs and the session settings followed by the non-zero timeout and pool settings
+func (p *PostgresDatabase) queryString() string {
+	params := []string{"sslmode=" + url.QueryEscape(p.SSLMode)}
+	if p.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+url.QueryEscape(p.SSLRootCert))
+	}
+	if p.SSLCert != "" {
+		params = append(params, "sslcert="+url.QueryEscape(p.SSLCert))
+	}
+	if p.SSLKey != "" {
+		params = append(params, "sslkey="+url.QueryEscape(p.SSLKey))
+	}
+	if p.SSLMinProtocolVersion != "" {
+		params = append(params, "ssl_min_protocol_version="+url.QueryEscape(p.SSLMinProtocolVersion))
+	}
+	if p.TargetSessionAttrs != "" {
+		params = append(params, "target_session_attrs="+url.QueryEscape(p.TargetSessionAttrs))
+	}
+	if p.ApplicationName != "" {
+		params = append(params, "application_name="+url.QueryEscape(p.ApplicationName))
+	}
+	if p.SearchPath != "" {
+		params = append(params, "search_path="+url.QueryEscape(p.SearchPath))
+	}
+	if p.StatementTimeout > 0 {
+		params = append(params, "statement_timeout="+formatMilliseconds(p.StatementTimeout))
+	}
+	if p.LockTimeout > 0 {
+		params = append(params, "lock_timeout="+formatMilliseconds(p.LockTimeout))
+	}
+	if len(p.RuntimeParams) > 0 {
+		params = append(params, "options="+url.QueryEscape(formatOptions(p.RuntimeParams)))
+	}
+	if timeout := p.EffectiveConnectTimeout(); timeout > 0 {
+		// libpq only takes whole seconds, round up so that the timeout is never shortened
+		seconds := (timeout + time.Second - 1) / time.Second
+		params = append(params, "connect_timeout="+strconv.FormatInt(int64(seconds), 10))
+	}
+	if p.MaxOpenConns > 0 {
+		params = append(params, "pool_max_conns="+strconv.Itoa(p.MaxOpenConns))
+	}
+	if p.MaxIdleConns > 0 {
+		params = append(params, "pool_min_conns="+strconv.Itoa(p.MaxIdleConns))
+	}
+	if lifetime := p.EffectiveMaxConnLifetime(); lifetime > 0 {
+		params = append(params, "pool_max_conn_lifetime="+lifetime.String())
+	}
+	if idleTime := p.EffectiveMaxConnIdleTime(); idleTime > 0 {
+		params = append(params, "pool_max_conn_idle_time="+idleTime.String())
+	}
+	return strings.Join(params, "&")
 }
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ProfileEnvVar is the environment variable selecting the active Profile
+const ProfileEnvVar = "MAPBOT_ENV"
+
+// Profile names a deployment environment, which selects the defaults of the settings
+type Profile string
+
+// Profiles, from the most permissive to the strictest
+const (
+	ProfileDev     Profile = "dev"     // defaults of NewPostgresDatabase
+	ProfileTest    Profile = "test"    // tiny pools, for tests against a throwaway database
+	ProfileStaging Profile = "staging" // TLS required
+	ProfileProd    Profile = "prod"    // TLS required and larger pools; see Validate
+)
+
+// profileAliases maps the accepted spellings of each profile
+var profileAliases = map[string]Profile{
+	"":            ProfileDev,
+	"dev":         ProfileDev,
+	"development": ProfileDev,
+	"local":       ProfileDev,
+	"test":        ProfileTest,
+	"testing":     ProfileTest,
+	"ci":          ProfileTest,
+	"staging":     ProfileStaging,
+	"stage":       ProfileStaging,
+	"prod":        ProfileProd,
+	"production":  ProfileProd,
+}
+
+// ParseProfile parses a profile name, case-insensitively. Besides the profile names,
+// development, local, testing, ci, stage and production are accepted, and an empty
+// name stands for ProfileDev.
+func ParseProfile(name string) (Profile, error) {
+	profile, ok := profileAliases[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", fmt.Errorf("unknown profile %q, expected dev, test, staging or prod", name)
+	}
+	return profile, nil
+}
+
+// ProfileFromEnv returns the profile named by MAPBOT_ENV, ProfileDev if it is not set
+func ProfileFromEnv() (Profile, error) {
+	profile, err := ParseProfile(os.Getenv(ProfileEnvVar))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ProfileEnvVar, err)
+	}
+	return profile, nil
+}
+
+// NewPostgresDatabaseForProfile creates a PostgresDatabase config with the defaults of
+// NewPostgresDatabase, replaced by the default.<profile> tags of profile, which Load
+// applies as well. The Profile field is set to profile.
+func NewPostgresDatabaseForProfile(profile Profile, host string, port int, database, user, password string) *PostgresDatabase {
+	cfg := NewPostgresDatabase(host, port, database, user, password)
+	cfg.Profile = profile
+	for _, s := range collectSettings(reflect.ValueOf(cfg).Elem(), "", "") {
+		if def, ok := s.tag.Lookup("default." + string(profile)); ok {
+			if err := setField(s.field, def, s.unit); err != nil {
+				panic(fmt.Sprintf("invalid default.%s tag of %s: %v", profile, s.key, err))
+			}
+		}
+	}
+	return cfg
+}
+
+// setProfile sets the Profile field of the PostgresDatabase sections of v that have none
+func setProfile(v reflect.Value, profile Profile) {
+	if cfg, ok := v.Addr().Interface().(*PostgresDatabase); ok {
+		if cfg.Profile == "" {
+			cfg.Profile = profile
+		}
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !v.Type().Field(i).IsExported() || !isSection(field) {
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		setProfile(field, profile)
+	}
+}
+
+// validateProfile applies the rules of the Profile field, or of MAPBOT_ENV when it is
+// empty, such as for ParsePostgresDatabase or NewPostgresDatabase: in prod, connections
+// to a host other than the local machine must not be able to go unencrypted, which
+// disable, allow and prefer (also the default of an empty SSLMode) all permit
+func (p *PostgresDatabase) validateProfile(v *validator) {
+	field, name := "Profile", string(p.Profile)
+	if name == "" {
+		field, name = ProfileEnvVar, os.Getenv(ProfileEnvVar)
+	}
+	profile, err := ParseProfile(name)
+	if err != nil {
+		v.add(field, "%v", err)
+		return
+	}
+	if profile != ProfileProd || !allowsPlaintext(p.SSLMode) {
+		return
+	}
+	for _, h := range p.Hosts() {
+		if !isLocalHost(h.Host) {
+			v.add("SSLMode", "%q is not allowed in the %s profile for the non-local host %s, use require or stronger",
+				p.SSLMode, profile, h.Host)
+			return
+		}
+	}
+}
+
+// allowsPlaintext reports whether sslmode lets a connection go unencrypted
+func allowsPlaintext(sslMode string) bool {
+	switch sslMode {
+	case "", "disable", "allow", "prefer":
+		return true
+	}
+	return false
+}
+
+// isLocalHost reports whether host is the local machine: localhost, a loopback address
+// or a Unix socket directory
+func isLocalHost(host string) bool {
+	if host == "" || strings.HasPrefix(host, "/") || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestParseProfile tests profile names and aliases
+func TestParseProfile(t *testing.T) {
+	tests := map[string]Profile{
+		"":           ProfileDev,
+		"dev":        ProfileDev,
+		"Local":      ProfileDev,
+		"test":       ProfileTest,
+		"CI":         ProfileTest,
+		"staging":    ProfileStaging,
+		"prod":       ProfileProd,
+		"production": ProfileProd,
+	}
+	for name, want := range tests {
+		if got, err := ParseProfile(name); err != nil || got != want {
+			t.Errorf("ParseProfile(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseProfile("qa"); err == nil {
+		t.Error("ParseProfile(qa) should fail")
+	}
+}
+
+// TestProfileDefaultTags tests that Load and NewPostgresDatabaseForProfile agree on the
+// defaults and the Profile field of every profile
+func TestProfileDefaultTags(t *testing.T) {
+	for _, profile := range []Profile{ProfileDev, ProfileTest, ProfileStaging, ProfileProd} {
+		t.Run(string(profile), func(t *testing.T) {
+			var got PostgresDatabase
+			err := Load(&got, WithProfile(profile), WithDotEnv(), WithEnvPrefix("PROFILE_TEST_"),
+				WithOverrides(map[string]string{"host": "db", "name": "mapbot", "user": "admin"}))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			want := NewPostgresDatabaseForProfile(profile, "db", 5432, "mapbot", "admin", "")
+			if !reflect.DeepEqual(&got, want) {
+				t.Errorf("Load() = %+v, want %+v", got, *want)
+			}
+		})
+	}
+}
+
+// TestProfileFromEnv tests that MAPBOT_ENV selects the defaults of every loader
+func TestProfileFromEnv(t *testing.T) {
+	t.Setenv("PROFILE_DB_HOST", "db.example.com")
+	t.Setenv("PROFILE_DB_NAME", "mapbot")
+	t.Setenv("PROFILE_DB_USER", "admin")
+
+	t.Setenv(ProfileEnvVar, "production")
+	cfg, err := LoadPostgresDatabaseFromEnv("PROFILE_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+	if cfg.SSLMode != "require" || cfg.MaxOpenConns != 50 {
+		t.Errorf("prod defaults = sslmode %s, %d connections", cfg.SSLMode, cfg.MaxOpenConns)
+	}
+
+	t.Setenv(ProfileEnvVar, "")
+	dotEnv := writeConfigFile(t, ".env", ProfileEnvVar+"=test\n")
+	var loaded PostgresDatabase
+	err = Load(&loaded, WithDotEnv(dotEnv), WithEnvPrefix("PROFILE_DB_"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.MaxOpenConns != 5 {
+		t.Errorf("MaxOpenConns with %s=test in .env = %d, want 5", ProfileEnvVar, loaded.MaxOpenConns)
+	}
+
+	t.Setenv(ProfileEnvVar, "qa")
+	var envErr *EnvError
+	if _, err := LoadPostgresDatabaseFromEnv("PROFILE_DB_"); !errors.As(err, &envErr) {
+		t.Errorf("LoadPostgresDatabaseFromEnv() with an unknown profile = %v, want an *EnvError", err)
+	}
+	if err := Load(&PostgresDatabase{}, WithDotEnv(), WithEnvPrefix("PROFILE_DB_")); err == nil {
+		t.Error("Load() with an unknown profile should fail")
+	}
+}
+
+// TestValidateProdSSLMode tests that prod refuses unencrypted connections to remote hosts
+func TestValidateProdSSLMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		fallback string
+		sslMode  string
+		valid    bool
+	}{
+		{"remote host without TLS", "db.example.com", "", "disable", false},
+		{"remote host with allow", "10.0.0.5", "", "allow", false},
+		{"remote host with prefer", "db.example.com", "", "prefer", false},
+		{"remote host without SSLMode", "db.example.com", "", "", false},
+		{"remote host with TLS", "db.example.com", "", "require", true},
+		{"localhost", "localhost", "", "disable", true},
+		{"loopback", "127.0.0.1", "", "disable", true},
+		{"IPv6 loopback", "::1", "", "disable", true},
+		{"Unix socket", "/var/run/postgresql", "", "disable", true},
+		{"remote fallback host", "localhost", "standby.example.com", "disable", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewPostgresDatabaseForProfile(ProfileProd, tt.host, 5432, "mapbot", "admin", "secret")
+			cfg.SSLMode = tt.sslMode
+			if tt.fallback != "" {
+				cfg.FallbackHosts = []HostPort{{Host: tt.fallback}}
+			}
+			err := cfg.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			var validationErr *ValidationError
+			if !tt.valid && (!errors.As(err, &validationErr) || validationErr.Field("SSLMode") == nil) {
+				t.Errorf("Validate() = %v, want an SSLMode error", err)
+			}
+		})
+	}
+
+	t.Setenv(ProfileEnvVar, "")
+	if err := NewPostgresDatabase("db.example.com", 5432, "mapbot", "admin", "").Validate(); err != nil {
+		t.Errorf("Validate() in dev error = %v", err)
+	}
+
+	// Without a Profile, such as from DATABASE_URL, MAPBOT_ENV applies
+	t.Setenv(ProfileEnvVar, "production")
+	var validationErr *ValidationError
+	parsed, err := ParsePostgresDatabase("postgres://u:p@db.prod.example.com/app?sslmode=disable")
+	if err != nil {
+		t.Fatalf("ParsePostgresDatabase() error = %v", err)
+	}
+	for name, cfg := range map[string]*PostgresDatabase{
+		"ParsePostgresDatabase": parsed,
+		"NewPostgresDatabase":   NewPostgresDatabase("db.prod.example.com", 5432, "mapbot", "admin", ""),
+	} {
+		if err := cfg.Validate(); !errors.As(err, &validationErr) || validationErr.Field("SSLMode") == nil {
+			t.Errorf("%s: Validate() with %s=production = %v, want an SSLMode error", name, ProfileEnvVar, err)
+		}
+	}
+	devCfg := NewPostgresDatabaseForProfile(ProfileDev, "db.example.com", 5432, "mapbot", "admin", "")
+	if err := devCfg.Validate(); err != nil {
+		t.Errorf("Validate() with the dev Profile error = %v", err)
+	}
+
+	t.Setenv(ProfileEnvVar, "qa")
+	err = NewPostgresDatabase("localhost", 5432, "mapbot", "admin", "").Validate()
+	if !errors.As(err, &validationErr) || validationErr.Field(ProfileEnvVar) == nil {
+		t.Errorf("Validate() with an unknown %s = %v", ProfileEnvVar, err)
+	}
+	if err := devCfg.Validate(); err != nil {
+		t.Errorf("Validate() with a Profile and an unknown %s error = %v", ProfileEnvVar, err)
+	}
+	cfg := NewPostgresDatabase("localhost", 5432, "mapbot", "admin", "")
+	cfg.Profile = "qa"
+	if err := cfg.Validate(); !errors.As(err, &validationErr) || validationErr.Field("Profile") == nil {
+		t.Errorf("Validate() with an unknown profile = %v", err)
+	}
+}
+
+// TestValidateLoadedProfile tests that Validate applies the profile chosen by Load, not
+// the one of the environment
+func TestValidateLoadedProfile(t *testing.T) {
+	overrides := WithOverrides(map[string]string{
+		"host": "db.example.com", "name": "mapbot", "user": "admin", "sslmode": "disable",
+	})
+	t.Setenv(ProfileEnvVar, "")
+
+	var withProfile PostgresDatabase
+	if err := Load(&withProfile, WithProfile(ProfileProd), WithDotEnv(), WithEnvPrefix("PROFILE_TEST_"), overrides); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var dotEnvProfile PostgresDatabase
+	dotEnv := writeConfigFile(t, ".env", ProfileEnvVar+"=production\n")
+	if err := Load(&dotEnvProfile, WithDotEnv(dotEnv), WithEnvPrefix("PROFILE_TEST_"), overrides); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for name, cfg := range map[string]*PostgresDatabase{"WithProfile": &withProfile, ".env": &dotEnvProfile} {
+		if cfg.Profile != ProfileProd {
+			t.Errorf("%s: Profile = %q, want prod", name, cfg.Profile)
+		}
+		var validationErr *ValidationError
+		if err := cfg.Validate(); !errors.As(err, &validationErr) || validationErr.Field("SSLMode") == nil {
+			t.Errorf("%s: Validate() = %v, want an SSLMode error", name, err)
+		}
+	}
+}
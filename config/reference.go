@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ReferenceEntry documents a setting
+type ReferenceEntry struct {
+	Env         string // environment variable, empty if the setting has none
+	Key         string // dotted file key
+	Type        string
+	Default     string   // default tag
+	Profiles    []string // default.<profile> tags, as "prod: require"
+	Required    bool
+	Secret      bool
+	Description string // desc tag
+}
+
+// Reference documents the settings of a configuration struct, from its tags
+type Reference struct {
+	Type    string // qualified name of the struct, such as config.PostgresDatabase
+	Entries []ReferenceEntry
+}
+
+// NewReference documents the settings of cfg, a struct or pointer to a struct tagged
+// for Load, whose environment variables start with envPrefix. A desc tag describes a
+// setting; the types, defaults, required and secret settings come from the other tags.
+func NewReference(cfg any, envPrefix string) (*Reference, error) {
+	t := reflect.TypeOf(cfg)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config.NewReference needs a struct, got %T", cfg)
+	}
+
+	ref := &Reference{Type: t.String()}
+	for _, s := range collectSettings(reflect.New(t).Elem(), "", envPrefix) {
+		ref.Entries = append(ref.Entries, ReferenceEntry{
+			Env:         s.env,
+			Key:         s.key,
+			Type:        typeName(s.field.Type(), s.unit),
+			Default:     s.def,
+			Profiles:    profileDefaults(s),
+			Required:    s.required,
+			Secret:      s.secret,
+			Description: s.tag.Get("desc"),
+		})
+	}
+	return ref, nil
+}
+
+// typeName describes a setting type for humans
+func typeName(t reflect.Type, unit string) string {
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+		return t.Name()
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		if unit != "" {
+			return "duration (integer in " + unit + ")"
+		}
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice:
+		return "list of " + typeName(t.Elem(), unit)
+	case reflect.Map:
+		return "list of name=value"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// profileDefaults lists the default.<profile> tags of a setting, as "prod: require"
+func profileDefaults(s *setting) []string {
+	var profiles []string
+	for _, profile := range []Profile{ProfileDev, ProfileTest, ProfileStaging, ProfileProd} {
+		if def, ok := s.tag.Lookup("default." + string(profile)); ok {
+			profiles = append(profiles, string(profile)+": "+def)
+		}
+	}
+	return profiles
+}
+
+// defaults renders the default followed by those of the profiles, such as
+// "disable (prod: require)"
+func (e ReferenceEntry) defaults() string {
+	if len(e.Profiles) == 0 {
+		return e.Default
+	}
+	return e.Default + " (" + strings.Join(e.Profiles, ", ") + ")"
+}
+
+// WriteMarkdown writes the reference as a Markdown table
+func (r *Reference) WriteMarkdown(w io.Writer) error {
+	escaper := strings.NewReplacer("|", `\|`, "\n", " ")
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!-- Generated from the tags of %s, do not edit. -->\n\n", r.Type)
+	b.WriteString("| Variable | Key | Type | Default | Description |\n")
+	b.WriteString("|----------|-----|------|---------|-------------|\n")
+	for _, e := range r.Entries {
+		env := ""
+		if e.Env != "" {
+			env = "`" + e.Env + "`"
+		}
+		def := ""
+		if e.Default != "" {
+			def = "`" + e.Default + "`"
+		}
+		if len(e.Profiles) > 0 {
+			def += " (" + strings.Join(e.Profiles, ", ") + ")"
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %s | %s |\n",
+			env, e.Key, escaper.Replace(e.Type), escaper.Replace(def), escaper.Replace(e.notes()))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDotEnv writes the reference as a commented .env template. Required variables
+// are listed with an empty value, the others are commented out with their default.
+func (r *Reference) WriteDotEnv(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated from the tags of %s, do not edit.\n", r.Type)
+	for _, e := range r.Entries {
+		if e.Env == "" {
+			continue
+		}
+		b.WriteString("\n")
+		if notes := e.notes(); notes != "" {
+			b.WriteString("# " + notes + "\n")
+		}
+		b.WriteString("# Type: " + e.Type)
+		if e.Default != "" {
+			b.WriteString(", default: " + e.defaults())
+		}
+		b.WriteString("\n")
+		if e.Required {
+			b.WriteString(e.Env + "=\n")
+		} else {
+			b.WriteString("# " + e.Env + "=" + e.Default + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// notes returns the description, with the required and secret marks
+func (e ReferenceEntry) notes() string {
+	var notes []string
+	if e.Required {
+		notes = append(notes, "Required.")
+	}
+	if e.Secret {
+		notes = append(notes, "Secret.")
+	}
+	if e.Description != "" {
+		notes = append(notes, e.Description)
+	}
+	return strings.Join(notes, " ")
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestNewReference tests the entries documented from the tags
+func TestNewReference(t *testing.T) {
+	ref, err := NewReference(&serviceConfig{}, "SVC_")
+	if err != nil {
+		t.Fatalf("NewReference() error = %v", err)
+	}
+	if ref.Type != "config.serviceConfig" {
+		t.Errorf("Type = %s", ref.Type)
+	}
+
+	entries := make(map[string]ReferenceEntry)
+	for _, entry := range ref.Entries {
+		entries[entry.Key] = entry
+	}
+	want := []ReferenceEntry{
+		{Env: "SVC_LISTEN", Key: "listen", Type: "string", Default: ":8080"},
+		{Env: "SVC_WORKERS", Key: "workers", Type: "integer", Default: "4"},
+		{Env: "SVC_TIMEOUT", Key: "timeout", Type: "duration", Default: "30s"},
+		{Env: "SVC_DB_HOST", Key: "database.host", Type: "string", Required: true,
+			Description: "Server host name, IP address or Unix socket directory"},
+		{Env: "SVC_DB_MAX_OPEN_CONNS", Key: "database.max_open_conns", Type: "integer", Default: "25",
+			Profiles: []string{"test: 5", "prod: 50"}, Description: "Maximum connections of each pool"},
+		{Env: "SVC_DB_FALLBACK_HOSTS", Key: "database.fallback_hosts", Type: "list of HostPort",
+			Description: "Hosts tried after the first one, such as standby1:5433,standby2"},
+	}
+	for _, w := range want {
+		if got := entries[w.Key]; !reflect.DeepEqual(got, w) {
+			t.Errorf("entry %s = %+v, want %+v", w.Key, got, w)
+		}
+	}
+	if !entries["database.password"].Secret {
+		t.Error("database.password should be secret")
+	}
+
+	if _, err := NewReference("not a struct", ""); err == nil {
+		t.Error("NewReference() of a string should fail")
+	}
+}
+
+// TestReferenceFormats tests the Markdown and .env renderings
+func TestReferenceFormats(t *testing.T) {
+	ref, err := NewReference(PostgresDatabase{}, "DB_")
+	if err != nil {
+		t.Fatalf("NewReference() error = %v", err)
+	}
+
+	var markdown bytes.Buffer
+	if err := ref.WriteMarkdown(&markdown); err != nil {
+		t.Fatalf("WriteMarkdown() error = %v", err)
+	}
+	for _, line := range []string{
+		"| `DB_HOST` | `host` | string |  | Required. Server host name, IP address or Unix socket directory |",
+		"| `DB_SSLMODE` | `sslmode` | string | `disable` (staging: require, prod: require) | disable, allow, prefer, require, verify-ca or verify-full |",
+	} {
+		if !strings.Contains(markdown.String(), line+"\n") {
+			t.Errorf("Markdown does not contain %q:\n%s", line, markdown.String())
+		}
+	}
+
+	var dotEnv bytes.Buffer
+	if err := ref.WriteDotEnv(&dotEnv); err != nil {
+		t.Fatalf("WriteDotEnv() error = %v", err)
+	}
+	for _, block := range []string{
+		"# Required. Database name\n# Type: string\nDB_NAME=\n",
+		"# Server port\n# Type: integer, default: 5432\n# DB_PORT=5432\n",
+	} {
+		if !strings.Contains(dotEnv.String(), block) {
+			t.Errorf(".env template does not contain %q:\n%s", block, dotEnv.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SecretProvider resolves the secret references of one URL scheme, such as
+// file:///run/secrets/db_password
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// SecretProviderFunc adapts a function to the SecretProvider interface
+type SecretProviderFunc func(ctx context.Context, ref *url.URL) (string, error)
+
+// Resolve calls f(ctx, ref)
+func (f SecretProviderFunc) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	return f(ctx, ref)
+}
+
+// SecretResolver replaces secret references with the secrets they point to, using the
+// provider registered for the scheme of the reference. Values whose scheme has no
+// provider are kept as they are, so plain passwords keep working.
+type SecretResolver struct {
+	providers map[string]SecretProvider
+}
+
+// NewSecretResolver returns a resolver for file:// and env:// references.
+//
+//	file:///run/secrets/db_password   content of the file, without trailing newline
+//	env://OTHER_VAR                    value of another environment variable
+func NewSecretResolver() *SecretResolver {
+	r := &SecretResolver{providers: make(map[string]SecretProvider)}
+	r.Register("file", SecretProviderFunc(resolveFileSecret))
+	r.Register("env", SecretProviderFunc(resolveEnvSecret))
+	return r
+}
+
+// Register sets the provider of a scheme, replacing any previous one
+func (r *SecretResolver) Register(scheme string, provider SecretProvider) {
+	r.providers[strings.ToLower(scheme)] = provider
+}
+
+// IsReference reports whether value is a reference to a registered scheme
+func (r *SecretResolver) IsReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	return ok && r.providers[strings.ToLower(scheme)] != nil
+}
+
+// Resolve returns the secret value refers to, or value itself if it is not a reference.
+// Errors mention the reference but never a resolved secret.
+func (r *SecretResolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !r.IsReference(value) {
+		return value, nil
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("malformed secret reference")
+	}
+	secret, err := r.providers[strings.ToLower(ref.Scheme)].Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve secret %s: %w", ref.Redacted(), err)
+	}
+	return secret, nil
+}
+
+// ResolveFields resolves the string fields tagged secret:"true" of the struct pointed to
+// by dst, descending into nested structs. Every failure is reported in a *LoadError.
+func (r *SecretResolver) ResolveFields(ctx context.Context, dst any) error {
+	loadErr := &LoadError{}
+	for _, s := range collectSettings(reflect.ValueOf(dst).Elem(), "", "") {
+		r.resolveSetting(ctx, s, loadErr)
+	}
+	if loadErr.empty() {
+		return nil
+	}
+	return loadErr
+}
+
+// resolveSetting resolves a secret setting in place, reporting failures in loadErr
+func (r *SecretResolver) resolveSetting(ctx context.Context, s *setting, loadErr *LoadError) {
+	if !s.secret || s.field.Kind() != reflect.String {
+		return
+	}
+	secret, err := r.Resolve(ctx, s.field.String())
+	if err != nil {
+		loadErr.Malformed = append(loadErr.Malformed, &SettingError{Key: s.key, Source: "secret reference", Err: err})
+		return
+	}
+	s.field.SetString(secret)
+}
+
+// resolveFileSecret reads file:///absolute/path or file://relative/path
+func resolveFileSecret(_ context.Context, ref *url.URL) (string, error) {
+	path := ref.Host + ref.Path
+	if path == "" {
+		return "", fmt.Errorf("missing file path")
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- path chosen by the operator
+	if err != nil {
+		return "", err
+	}
+	// Secret files written by editors or by Kubernetes often end with a newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveEnvSecret reads env://NAME
+func resolveEnvSecret(_ context.Context, ref *url.URL) (string, error) {
+	value, ok := os.LookupEnv(ref.Host)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref.Host)
+	}
+	return value, nil
+}
+
+// VaultSecretProvider resolves references to a key of a HashiCorp Vault (or compatible)
+// KV version 2 secret, written vault://<mount>/<path>#<key>, for example
+// vault://secret/mapbot/database#password
+type VaultSecretProvider struct {
+	Address string // e.g. https://vault.internal:8200
+	Token   string
+	Client  *http.Client
+}
+
+// NewVaultSecretProvider returns a provider for the Vault server at address,
+// authenticated with token, using a client with a 10 second timeout
+func NewVaultSecretProvider(address, token string) *VaultSecretProvider {
+	return &VaultSecretProvider{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve implements SecretProvider
+func (v *VaultSecretProvider) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	mount, path, key := ref.Host, strings.Trim(ref.Path, "/"), ref.Fragment
+	if mount == "" || path == "" || key == "" {
+		return "", fmt.Errorf("expected vault://<mount>/<path>#<key>")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Address+"/v1/"+mount+"/data/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	value, ok := body.Data.Data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("key %q not found or not a string", key)
+	}
+	return value, nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSecretResolver tests the built-in file and env providers
+func TestSecretResolver(t *testing.T) {
+	secretFile := writeConfigFile(t, "db_password", "s3cr3t-from-file\n")
+	t.Setenv("RESOLVER_OTHER_VAR", "s3cr3t-from-env")
+	missingFile := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain value", value: "plain-password", want: "plain-password"},
+		{name: "unregistered scheme", value: "https://example.com", want: "https://example.com"},
+		{name: "file", value: "file://" + secretFile, want: "s3cr3t-from-file"},
+		{name: "env", value: "env://RESOLVER_OTHER_VAR", want: "s3cr3t-from-env"},
+		{name: "missing file", value: "file://" + missingFile, wantErr: true},
+		{name: "unset variable", value: "env://RESOLVER_UNSET_VAR", wantErr: true},
+	}
+
+	resolver := NewSecretResolver()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.Resolve(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// newVaultStub serves KV v2 secrets for the token "test-token"
+func newVaultStub(t *testing.T, secrets map[string]map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		data, ok := secrets[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestVaultSecretProvider tests resolving KV v2 secrets against a local Vault stub
+func TestVaultSecretProvider(t *testing.T) {
+	server := newVaultStub(t, map[string]map[string]string{
+		"/v1/secret/data/mapbot/database": {"password": "s3cr3t-from-vault"},
+	})
+
+	resolver := NewSecretResolver()
+	resolver.Register("vault", NewVaultSecretProvider(server.URL, "test-token"))
+
+	got, err := resolver.Resolve(context.Background(), "vault://secret/mapbot/database#password")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got != "s3cr3t-from-vault" {
+		t.Errorf("Resolve() = %q, want s3cr3t-from-vault", got)
+	}
+
+	for _, ref := range []string{
+		"vault://secret/mapbot/database#username",
+		"vault://secret/mapbot/other#password",
+		"vault://secret/mapbot/database",
+	} {
+		if _, err := resolver.Resolve(context.Background(), ref); err == nil {
+			t.Errorf("Resolve(%q) should fail", ref)
+		}
+	}
+
+	denied := NewSecretResolver()
+	denied.Register("vault", NewVaultSecretProvider(server.URL, "wrong-token"))
+	if _, err := denied.Resolve(context.Background(), "vault://secret/mapbot/database#password"); err == nil {
+		t.Error("Resolve() with a wrong token should fail")
+	}
+}
+
+// TestLoadResolvesSecrets tests secret references in files, the environment and LoadPostgresDatabaseFromEnv
+func TestLoadResolvesSecrets(t *testing.T) {
+	server := newVaultStub(t, map[string]map[string]string{
+		"/v1/secret/data/mapbot/database": {"password": "s3cr3t-from-vault"},
+	})
+	resolver := NewSecretResolver()
+	resolver.Register("vault", NewVaultSecretProvider(server.URL, "test-token"))
+
+	file := writeConfigFile(t, "service.yaml", `
+database:
+  host: localhost
+  name: mapbot
+  user: mapbot
+  password: vault://secret/mapbot/database#password
+`)
+	var cfg serviceConfig
+	if err := Load(&cfg, WithFile(file), WithDotEnv(), WithEnvPrefix("SECRETS_"), WithSecretResolver(resolver)); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Database.Password != "s3cr3t-from-vault" {
+		t.Errorf("Password = %q, want s3cr3t-from-vault", cfg.Database.Password)
+	}
+
+	t.Setenv("SECRETS_DB_PASSWORD", "vault://secret/mapbot/missing#password")
+	err := Load(&cfg, WithFile(file), WithDotEnv(), WithEnvPrefix("SECRETS_"), WithSecretResolver(resolver))
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) || len(loadErr.Malformed) != 1 || loadErr.Malformed[0].Key != "database.password" {
+		t.Errorf("Load() error = %v, want a malformed database.password", err)
+	}
+
+	secretFile := writeConfigFile(t, "db_password", "s3cr3t-from-file\n")
+	t.Setenv("SECRETS_ENV_DB_HOST", "localhost")
+	t.Setenv("SECRETS_ENV_DB_NAME", "mapbot")
+	t.Setenv("SECRETS_ENV_DB_USER", "mapbot")
+	t.Setenv("SECRETS_ENV_DB_PASSWORD", "file://"+secretFile)
+	db, err := LoadPostgresDatabaseFromEnv("SECRETS_ENV_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+	if db.Password != "s3cr3t-from-file" {
+		t.Errorf("Password = %q, want s3cr3t-from-file", db.Password)
+	}
+}
+
+// TestResolvedSecretsAreNotPrinted tests that resolved secrets stay out of logs and public strings
+func TestResolvedSecretsAreNotPrinted(t *testing.T) {
+	t.Setenv("PRINT_DB_HOST", "localhost")
+	t.Setenv("PRINT_DB_NAME", "mapbot")
+	t.Setenv("PRINT_DB_USER", "mapbot")
+	t.Setenv("PRINT_DB_PASSWORD", "env://PRINT_SECRET")
+	t.Setenv("PRINT_SECRET", "s3cr3t-value")
+
+	db, err := LoadPostgresDatabaseFromEnv("PRINT_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+	if !strings.Contains(db.ConnectionString(), "s3cr3t-value") {
+		t.Fatal("ConnectionString() should contain the resolved password")
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	logger.Info("connecting", "database", db)
+	logger.Info("connecting", slog.Any("database", db))
+	logger.Info("connecting", "database", *db)
+
+	for name, out := range map[string]string{
+		"PublicConnectionString": db.PublicConnectionString(),
+		"fmt":                    fmt.Sprintf("%v %s", db, db),
+		"fmt of a value":         fmt.Sprintf("%v %s %+v", *db, *db, *db),
+		"slog":                   logs.String(),
+	} {
+		if strings.Contains(out, "s3cr3t-value") {
+			t.Errorf("%s leaks the secret: %s", name, out)
+		}
+		if !strings.Contains(out, "localhost") {
+			t.Errorf("%s should describe the database: %s", name, out)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServiceFiles returns the paths of the libpq connection service files, in the order
+// they are searched: PGSERVICEFILE if set, otherwise ~/.pg_service.conf, then
+// pg_service.conf in PGSYSCONFDIR if set
+func ServiceFiles() []string {
+	var paths []string
+	if path := os.Getenv("PGSERVICEFILE"); path != "" {
+		paths = append(paths, path)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "pg_service.conf"))
+	}
+	return paths
+}
+
+// LoadPostgresDatabaseFromService builds a PostgresDatabase from the named section of
+// the connection service file (see ServiceFiles), as psql does for "service=name". An
+// empty name uses PGSERVICE. The section holds libpq keywords (host, port, dbname, user,
+// sslmode...) with the meaning they have in ParsePostgresDatabase, which also accepts a
+// service keyword. A missing password is then looked up in the password file, see
+// ApplyPgpass.
+func LoadPostgresDatabaseFromService(name string) (*PostgresDatabase, error) {
+	if name == "" {
+		name = os.Getenv("PGSERVICE")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no service name given and PGSERVICE is not set")
+	}
+
+	settings, err := lookupService(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg := NewPostgresDatabase("localhost", 5432, "", "", "")
+	if err := applySettings(cfg, settings); err != nil {
+		return nil, fmt.Errorf("invalid service %q: %w", name, err)
+	}
+	if err := cfg.ApplyPgpass(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// lookupService returns the settings of the first definition of the service in the
+// service files
+func lookupService(name string) (map[string]string, error) {
+	for _, path := range ServiceFiles() {
+		settings, err := readServiceFile(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if settings != nil {
+			return settings, nil
+		}
+	}
+	return nil, fmt.Errorf("definition of service %q not found", name)
+}
+
+// readServiceFile returns the settings of the service in the INI file at path, or nil
+// if the file or the service does not exist
+func readServiceFile(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("service file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var settings map[string]string
+	inService := false
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if inService {
+				break
+			}
+			inService = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]") == name
+			if inService {
+				settings = make(map[string]string)
+			}
+			continue
+		}
+		if !inService {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("syntax error in service file %s, line %d", path, lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		if key == "service" {
+			return nil, fmt.Errorf("nested service specifications not supported in service file %s, line %d", path, lineNumber)
+		}
+		settings[key] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("service file: %w", err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testServiceFile = `
+# Comment
+[mapbot]
+host=db.example.com
+port = 5433
+dbname=mapbot
+user=admin
+sslmode=require
+connect_timeout=5
+
+[broken]
+host
+`
+
+// TestLoadPostgresDatabaseFromService tests services of the user and system files, and
+// the password file lookup
+func TestLoadPostgresDatabaseFromService(t *testing.T) {
+	t.Setenv("PGSERVICEFILE", writeConfigFile(t, "pg_service.conf", testServiceFile))
+	system := writeConfigFile(t, "pg_service.conf", "[reporting]\nhost=reports.example.com\ndbname=reports\nuser=reader\n")
+	t.Setenv("PGSYSCONFDIR", filepath.Dir(system))
+	t.Setenv("PGPASSFILE", writeConfigFile(t, "pgpass", "db.example.com:5433:mapbot:admin:from-pgpass\n"))
+	t.Setenv("PGSERVICE", "reporting")
+
+	cfg, err := LoadPostgresDatabaseFromService("mapbot")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromService() error = %v", err)
+	}
+	want := NewPostgresDatabase("db.example.com", 5433, "mapbot", "admin", "from-pgpass")
+	want.SSLMode = "require"
+	want.ConnectTimeout = 5 * time.Second
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadPostgresDatabaseFromService() = %+v, want %+v", *cfg, *want)
+	}
+
+	cfg, err = LoadPostgresDatabaseFromService("")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromService(PGSERVICE) error = %v", err)
+	}
+	if cfg.Host != "reports.example.com" || cfg.Database != "reports" || cfg.Port != 5432 {
+		t.Errorf("LoadPostgresDatabaseFromService(PGSERVICE) = %+v", *cfg)
+	}
+
+	for _, name := range []string{"unknown", "broken"} {
+		if _, err := LoadPostgresDatabaseFromService(name); err == nil {
+			t.Errorf("LoadPostgresDatabaseFromService(%q) should fail", name)
+		}
+	}
+}
+
+// TestParsePostgresDatabaseService tests that connection string settings apply over the service
+func TestParsePostgresDatabaseService(t *testing.T) {
+	t.Setenv("PGSERVICEFILE", writeConfigFile(t, "pg_service.conf", testServiceFile))
+
+	cfg, err := ParsePostgresDatabase("service=mapbot dbname=other password=secret")
+	if err != nil {
+		t.Fatalf("ParsePostgresDatabase() error = %v", err)
+	}
+	if cfg.Host != "db.example.com" || cfg.Port != 5433 || cfg.Database != "other" || cfg.Password != "secret" {
+		t.Errorf("ParsePostgresDatabase() = %+v", *cfg)
+	}
+}
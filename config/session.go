@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionParams lists the server settings that have a PostgresDatabase field, and
+// therefore cannot be given in RuntimeParams as well
+var sessionParams = map[string]string{
+	"application_name":  "ApplicationName",
+	"search_path":       "SearchPath",
+	"statement_timeout": "StatementTimeout",
+	"lock_timeout":      "LockTimeout",
+}
+
+// validateSession checks the session settings
+func (p *PostgresDatabase) validateSession(v *validator) {
+	if p.StatementTimeout < 0 {
+		v.add("StatementTimeout", "cannot be negative, got %s", p.StatementTimeout)
+	}
+	if p.LockTimeout < 0 {
+		v.add("LockTimeout", "cannot be negative, got %s", p.LockTimeout)
+	}
+	for _, name := range sortedKeys(p.RuntimeParams) {
+		switch {
+		case name == "" || strings.ContainsAny(name, " =\\"):
+			v.add("RuntimeParams", "invalid parameter name %q", name)
+		case sessionParams[strings.ToLower(name)] != "":
+			v.add("RuntimeParams", "%s is set with the %s field", name, sessionParams[strings.ToLower(name)])
+		}
+	}
+}
+
+// formatMilliseconds renders a timeout in the default unit of the server, rounding up so
+// that a timeout is never shortened (nor turned into 0, which disables it)
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+}
+
+// parseServerDuration parses a timeout as given to the server: milliseconds, or a value
+// with a unit in the time.ParseDuration syntax, "min" and "d" included
+func parseServerDuration(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	value = strings.ReplaceAll(value, " ", "")
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a duration")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(strings.Replace(value, "min", "m", 1))
+	if err != nil {
+		return 0, fmt.Errorf("not a duration")
+	}
+	return d, nil
+}
+
+// formatOptions renders runtime parameters as the libpq options keyword:
+// "-c name=value" for each, spaces and backslashes of values escaped with a backslash
+func formatOptions(params map[string]string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, " ", `\ `)
+	options := make([]string, 0, len(params))
+	for _, name := range sortedKeys(params) {
+		options = append(options, "-c "+name+"="+escaper.Replace(params[name]))
+	}
+	return strings.Join(options, " ")
+}
+
+// applyOptions fills RuntimeParams from the libpq options keyword. Settings with a
+// field fill it, unless it is already set.
+func applyOptions(cfg *PostgresDatabase, options string) error {
+	params, err := parseOptions(options)
+	if err != nil {
+		return err
+	}
+	for name, value := range params {
+		switch strings.ToLower(name) {
+		case "application_name":
+			if cfg.ApplicationName == "" {
+				cfg.ApplicationName = value
+			}
+		case "search_path":
+			if cfg.SearchPath == "" {
+				cfg.SearchPath = value
+			}
+		case "statement_timeout":
+			if cfg.StatementTimeout == 0 {
+				cfg.StatementTimeout, err = parseServerDuration(value)
+			}
+		case "lock_timeout":
+			if cfg.LockTimeout == 0 {
+				cfg.LockTimeout, err = parseServerDuration(value)
+			}
+		default:
+			if cfg.RuntimeParams == nil {
+				cfg.RuntimeParams = make(map[string]string)
+			}
+			cfg.RuntimeParams[name] = value
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseOptions parses the libpq options keyword into runtime parameters. Only the
+// "-c name=value", "-cname=value" and "--name=value" forms are understood.
+func parseOptions(options string) (map[string]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(options); i++ {
+		c := options[i]
+		switch {
+		case c == '\\' && i+1 < len(options):
+			i++
+			arg.WriteByte(options[i])
+			inArg = true
+		case isSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	params := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		setting := ""
+		switch {
+		case args[i] == "-c" && i+1 < len(args):
+			i++
+			setting = args[i]
+		case strings.HasPrefix(args[i], "-c"):
+			setting = args[i][2:]
+		case strings.HasPrefix(args[i], "--"):
+			setting = args[i][2:]
+		default:
+			return nil, fmt.Errorf("unsupported option %q", args[i])
+		}
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("option %q is not name=value", setting)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
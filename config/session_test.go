@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionConfig returns a configuration with every session setting
+func sessionConfig() *PostgresDatabase {
+	cfg := NewPostgresDatabase("localhost", 5432, "mapbot", "admin", "secret")
+	cfg.ApplicationName = "mapbot-tiles"
+	cfg.SearchPath = "admin,public,postgis"
+	cfg.StatementTimeout = 30 * time.Second
+	cfg.LockTimeout = 1500 * time.Microsecond
+	cfg.RuntimeParams = map[string]string{"work_mem": "64MB", "TimeZone": "America/New York", "x.path": `C:\tmp`}
+	return cfg
+}
+
+// TestSessionConnectionString tests how session settings are encoded
+func TestSessionConnectionString(t *testing.T) {
+	u, err := url.Parse(sessionConfig().ConnectionString())
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	query := u.Query()
+	want := map[string]string{
+		"application_name":  "mapbot-tiles",
+		"search_path":       "admin,public,postgis",
+		"statement_timeout": "30000",
+		"lock_timeout":      "2",
+		"options":           `-c TimeZone=America/New\ York -c work_mem=64MB -c x.path=C:\\tmp`,
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+// TestSessionRoundTrip tests that ParsePostgresDatabase reads back every session setting
+func TestSessionRoundTrip(t *testing.T) {
+	cfg := sessionConfig()
+	cfg.LockTimeout = 2 * time.Millisecond
+	got, err := ParsePostgresDatabase(cfg.ConnectionString())
+	if err != nil {
+		t.Fatalf("ParsePostgresDatabase() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("round trip = %+v, want %+v", *got, *cfg)
+	}
+}
+
+// TestParseOptions tests the options forms and the session settings they may hold
+func TestParseOptions(t *testing.T) {
+	cfg, err := ParsePostgresDatabase(`host=localhost search_path=explicit ` +
+		`options='-c search_path=ignored -cstatement_timeout=5s --geqo=off -c lock_timeout=1min'`)
+	if err != nil {
+		t.Fatalf("ParsePostgresDatabase() error = %v", err)
+	}
+	if cfg.SearchPath != "explicit" || cfg.StatementTimeout != 5*time.Second || cfg.LockTimeout != time.Minute {
+		t.Errorf("session settings = %q, %s, %s", cfg.SearchPath, cfg.StatementTimeout, cfg.LockTimeout)
+	}
+	if !reflect.DeepEqual(cfg.RuntimeParams, map[string]string{"geqo": "off"}) {
+		t.Errorf("RuntimeParams = %v", cfg.RuntimeParams)
+	}
+
+	for _, options := range []string{"-x", "-c novalue", "-c statement_timeout=soon"} {
+		if _, err := ParsePostgresDatabase("host=localhost options='" + options + "'"); err == nil {
+			t.Errorf("options %q should be rejected", options)
+		}
+	}
+}
+
+// TestParseServerDuration tests the timeout formats of the server
+func TestParseServerDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"250":   250 * time.Millisecond,
+		"5s":    5 * time.Second,
+		"2min":  2 * time.Minute,
+		"1 h":   time.Hour,
+		"1d":    24 * time.Hour,
+		"100ms": 100 * time.Millisecond,
+	}
+	for value, want := range tests {
+		got, err := parseServerDuration(value)
+		if err != nil || got != want {
+			t.Errorf("parseServerDuration(%q) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+}
+
+// TestValidateSession tests the validation of session settings
+func TestValidateSession(t *testing.T) {
+	cfg := sessionConfig()
+	cfg.StatementTimeout = -time.Second
+	cfg.RuntimeParams["Search_Path"] = "public"
+	cfg.RuntimeParams["bad name"] = "x"
+
+	var validationErr *ValidationError
+	if err := cfg.Validate(); !errors.As(err, &validationErr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	if validationErr.Field("StatementTimeout") == nil {
+		t.Error("a negative StatementTimeout should be reported")
+	}
+	reported := 0
+	for _, fieldErr := range validationErr.Errors {
+		if fieldErr.Field == "RuntimeParams" {
+			reported++
+		}
+	}
+	if reported != 2 || !strings.Contains(validationErr.Error(), "SearchPath field") {
+		t.Errorf("Validate() = %v, want both RuntimeParams errors", validationErr)
+	}
+}
+
+// TestLoadSessionFromEnv tests the session variables
+func TestLoadSessionFromEnv(t *testing.T) {
+	t.Setenv("SESSION_DB_HOST", "localhost")
+	t.Setenv("SESSION_DB_NAME", "mapbot")
+	t.Setenv("SESSION_DB_USER", "admin")
+	t.Setenv("SESSION_DB_STATEMENT_TIMEOUT", "5000")
+	t.Setenv("SESSION_DB_LOCK_TIMEOUT", "2s")
+	t.Setenv("SESSION_DB_RUNTIME_PARAMS", "work_mem=64MB, jit=off")
+
+	cfg, err := LoadPostgresDatabaseFromEnv("SESSION_DB_")
+	if err != nil {
+		t.Fatalf("LoadPostgresDatabaseFromEnv() error = %v", err)
+	}
+	if cfg.StatementTimeout != 5*time.Second || cfg.LockTimeout != 2*time.Second {
+		t.Errorf("timeouts = %s, %s", cfg.StatementTimeout, cfg.LockTimeout)
+	}
+	if !reflect.DeepEqual(cfg.RuntimeParams, map[string]string{"work_mem": "64MB", "jit": "off"}) {
+		t.Errorf("RuntimeParams = %v", cfg.RuntimeParams)
+	}
+
+	t.Setenv("SESSION_DB_RUNTIME_PARAMS", "work_mem")
+	if _, err := LoadPostgresDatabaseFromEnv("SESSION_DB_"); err == nil {
+		t.Error("a runtime parameter without value should be rejected")
+	}
+}
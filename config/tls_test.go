@@ -0,0 +1,289 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, both PEM-encoded
+type testCert struct {
+	certPEM []byte
+	keyPEM  []byte
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate signed by parent, or self-signed when parent is nil
+func newTestCert(t *testing.T, commonName string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{commonName}
+	}
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return &testCert{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		cert:    cert,
+		key:     key,
+	}
+}
+
+// writeTestFile writes data to a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// TestTLSConfigInvalidCombinations tests that inconsistent TLS settings are rejected
+func TestTLSConfigInvalidCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		db   PostgresDatabase
+	}{
+		{"unknown sslmode", PostgresDatabase{SSLMode: "always"}},
+		{"verify-full without CA", PostgresDatabase{SSLMode: "verify-full"}},
+		{"verify-ca without CA", PostgresDatabase{SSLMode: "verify-ca"}},
+		{"options with sslmode disable", PostgresDatabase{SSLMode: "disable", SSLServerName: "db"}},
+		{"CA path and PEM", PostgresDatabase{SSLMode: "verify-full", SSLRootCert: "/ca.crt", SSLRootCertPEM: "pem"}},
+		{"certificate path and PEM", PostgresDatabase{SSLMode: "require", SSLCert: "/c.crt", SSLCertPEM: "pem", SSLKey: "/c.key"}},
+		{"key path and PEM", PostgresDatabase{SSLMode: "require", SSLCert: "/c.crt", SSLKey: "/c.key", SSLKeyPEM: "pem"}},
+		{"certificate without key", PostgresDatabase{SSLMode: "require", SSLCert: "/c.crt"}},
+		{"key without certificate", PostgresDatabase{SSLMode: "require", SSLKeyPEM: "pem"}},
+		{"unknown min version", PostgresDatabase{SSLMode: "require", SSLMinProtocolVersion: "1.2"}},
+		{"missing CA file", PostgresDatabase{SSLMode: "verify-full", SSLRootCert: "/does/not/exist.crt"}},
+		{"invalid CA PEM", PostgresDatabase{SSLMode: "verify-full", SSLRootCertPEM: "not a certificate"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.db.TLSConfig("localhost"); err == nil {
+				t.Errorf("TLSConfig() should fail for %+v", tt.db)
+			}
+		})
+	}
+}
+
+// TestTLSConfigModes tests the libpq semantics of each sslmode
+func TestTLSConfigModes(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+
+	t.Run("disable", func(t *testing.T) {
+		db := PostgresDatabase{SSLMode: "disable"}
+		tlsConfig, err := db.TLSConfig("localhost")
+		if err != nil || tlsConfig != nil {
+			t.Errorf("TLSConfig() = %v, %v, want nil, nil", tlsConfig, err)
+		}
+	})
+
+	t.Run("require without CA skips verification", func(t *testing.T) {
+		db := PostgresDatabase{SSLMode: "require"}
+		tlsConfig, err := db.TLSConfig("db.example.com")
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+		if !tlsConfig.InsecureSkipVerify || tlsConfig.VerifyPeerCertificate != nil {
+			t.Error("require without CA should not verify the server certificate")
+		}
+		if tlsConfig.ServerName != "db.example.com" {
+			t.Errorf("ServerName = %q, want db.example.com for SNI", tlsConfig.ServerName)
+		}
+	})
+
+	t.Run("require with CA verifies the chain", func(t *testing.T) {
+		db := PostgresDatabase{SSLMode: "require", SSLRootCertPEM: string(ca.certPEM)}
+		tlsConfig, err := db.TLSConfig("db.example.com")
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+		if tlsConfig.VerifyPeerCertificate == nil {
+			t.Error("require with CA should behave as verify-ca")
+		}
+	})
+
+	t.Run("verify-full verifies the host name", func(t *testing.T) {
+		db := PostgresDatabase{SSLMode: "verify-full", SSLRootCertPEM: string(ca.certPEM)}
+		tlsConfig, err := db.TLSConfig("10.0.0.5")
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+		if tlsConfig.InsecureSkipVerify {
+			t.Error("verify-full should not skip verification")
+		}
+		if tlsConfig.ServerName != "10.0.0.5" {
+			t.Errorf("ServerName = %q, want 10.0.0.5", tlsConfig.ServerName)
+		}
+	})
+
+	t.Run("min protocol version", func(t *testing.T) {
+		db := PostgresDatabase{SSLMode: "require", SSLMinProtocolVersion: "TLSv1.3"}
+		tlsConfig, err := db.TLSConfig("localhost")
+		if err != nil {
+			t.Fatalf("TLSConfig() error = %v", err)
+		}
+		if tlsConfig.MinVersion != tls.VersionTLS13 {
+			t.Errorf("MinVersion = %x, want %x", tlsConfig.MinVersion, tls.VersionTLS13)
+		}
+	})
+}
+
+// TestTLSConfigFilesAndPEM tests that file paths and in-memory PEM are equivalent
+func TestTLSConfigFilesAndPEM(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+	client := newTestCert(t, "mapbot", false, ca)
+
+	fromPEM := PostgresDatabase{
+		SSLMode:        "verify-full",
+		SSLRootCertPEM: string(ca.certPEM),
+		SSLCertPEM:     string(client.certPEM),
+		SSLKeyPEM:      string(client.keyPEM),
+	}
+	fromFiles := PostgresDatabase{
+		SSLMode:     "verify-full",
+		SSLRootCert: writeTestFile(t, "root.crt", ca.certPEM),
+		SSLCert:     writeTestFile(t, "client.crt", client.certPEM),
+		SSLKey:      writeTestFile(t, "client.key", client.keyPEM),
+	}
+
+	pemConfig, err := fromPEM.TLSConfig("db")
+	if err != nil {
+		t.Fatalf("TLSConfig() from PEM error = %v", err)
+	}
+	fileConfig, err := fromFiles.TLSConfig("db")
+	if err != nil {
+		t.Fatalf("TLSConfig() from files error = %v", err)
+	}
+
+	if !pemConfig.RootCAs.Equal(fileConfig.RootCAs) {
+		t.Error("RootCAs differ between PEM and file configuration")
+	}
+	if len(pemConfig.Certificates) != 1 || len(fileConfig.Certificates) != 1 {
+		t.Fatal("both configurations should carry the client certificate")
+	}
+	if !pemConfig.Certificates[0].Leaf.Equal(fileConfig.Certificates[0].Leaf) {
+		t.Error("client certificates differ between PEM and file configuration")
+	}
+}
+
+// TestTLSConfigHandshake tests verify-full with a server name override and client certificate
+// authentication against an in-process TLS server
+func TestTLSConfigHandshake(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+	server := newTestCert(t, "postgis.internal", false, ca)
+	client := newTestCert(t, "mapbot", false, ca)
+	otherCA := newTestCert(t, "Other CA", true, nil)
+
+	serverPair, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load server key pair: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	handshake := func(db *PostgresDatabase, host string) error {
+		clientConfig, err := db.TLSConfig(host)
+		if err != nil {
+			return err
+		}
+
+		clientConn, serverConn := net.Pipe()
+		defer func() { _ = clientConn.Close() }()
+
+		serverErr := make(chan error, 1)
+		go func() {
+			defer func() { _ = serverConn.Close() }()
+			srv := tls.Server(serverConn, &tls.Config{
+				Certificates: []tls.Certificate{serverPair},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientCAs,
+				MinVersion:   tls.VersionTLS12,
+			})
+			serverErr <- srv.Handshake()
+		}()
+
+		clientErr := tls.Client(clientConn, clientConfig).Handshake()
+		_ = clientConn.Close()
+		if err := <-serverErr; clientErr == nil && err != nil {
+			return err
+		}
+		return clientErr
+	}
+
+	db := &PostgresDatabase{
+		SSLMode:        "verify-full",
+		SSLRootCertPEM: string(ca.certPEM),
+		SSLCertPEM:     string(client.certPEM),
+		SSLKeyPEM:      string(client.keyPEM),
+	}
+
+	// Connecting by IP fails host name verification unless the server name is overridden
+	if err := handshake(db, "10.0.0.5"); err == nil {
+		t.Error("verify-full should reject a certificate that does not match the host")
+	}
+	db.SSLServerName = "postgis.internal"
+	if err := handshake(db, "10.0.0.5"); err != nil {
+		t.Errorf("verify-full with SSLServerName failed: %v", err)
+	}
+
+	// verify-ca ignores the host name but not the chain
+	verifyCA := *db
+	verifyCA.SSLMode = "verify-ca"
+	verifyCA.SSLServerName = ""
+	if err := handshake(&verifyCA, "10.0.0.5"); err != nil {
+		t.Errorf("verify-ca failed: %v", err)
+	}
+	verifyCA.SSLRootCertPEM = string(otherCA.certPEM)
+	if err := handshake(&verifyCA, "10.0.0.5"); err == nil {
+		t.Error("verify-ca should reject a certificate signed by another CA")
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes why a single PostgresDatabase field is invalid
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// ValidationError lists every invalid field of a PostgresDatabase
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		msgs[i] = fieldErr.Error()
+	}
+	return "invalid database configuration: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap exposes each FieldError to errors.Is and errors.As
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		errs[i] = fieldErr
+	}
+	return errs
+}
+
+// Field returns the error reported for the named field, or nil if the field is valid
+func (e *ValidationError) Field(name string) *FieldError {
+	for _, fieldErr := range e.Errors {
+		if fieldErr.Field == name {
+			return fieldErr
+		}
+	}
+	return nil
+}
+
+// validator accumulates field errors
+type validator struct {
+	errs []*FieldError
+}
+
+func (v *validator) add(field, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// Validate checks every field and returns a *ValidationError naming each invalid field,
+// or nil. It does not read certificate files, TLSConfig does.
+//
+// In the prod profile (the Profile field, or MAPBOT_ENV when it is empty), SSLMode
+// disable, allow or prefer is refused unless every host is the local machine. An unknown
+// profile is reported under Profile, or under MAPBOT_ENV when taken from it.
+func (p *PostgresDatabase) Validate() error {
+	v := &validator{}
+
+	if p.Host == "" {
+		v.add("Host", "cannot be empty")
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		v.add("Port", "must be between 1 and 65535, got %d", p.Port)
+	}
+	if p.Database == "" {
+		v.add("Database", "cannot be empty")
+	}
+	if p.User == "" {
+		v.add("User", "cannot be empty")
+	}
+
+	p.validateHosts(v)
+	p.validateTLS(v)
+	p.validateSession(v)
+	p.validateProfile(v)
+
+	if p.MaxOpenConns < 1 {
+		v.add("MaxOpenConns", "must be positive, got %d", p.MaxOpenConns)
+	}
+	if p.MaxIdleConns < 0 {
+		v.add("MaxIdleConns", "cannot be negative, got %d", p.MaxIdleConns)
+	} else if p.MaxOpenConns >= 1 && p.MaxIdleConns > p.MaxOpenConns {
+		v.add("MaxIdleConns", "cannot exceed MaxOpenConns (%d), got %d", p.MaxOpenConns, p.MaxIdleConns)
+	}
+	if p.EffectiveMaxConnLifetime() < 0 {
+		v.add("MaxConnLifetime", "cannot be negative, got %s", p.EffectiveMaxConnLifetime())
+	}
+	if p.EffectiveMaxConnIdleTime() < 0 {
+		v.add("MaxConnIdleTime", "cannot be negative, got %s", p.EffectiveMaxConnIdleTime())
+	}
+	if p.EffectiveConnectTimeout() <= 0 {
+		v.add("ConnectTimeout", "must be positive, otherwise the connection check expires immediately, got %s",
+			p.EffectiveConnectTimeout())
+	}
+
+	return v.err()
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidateDefaults tests that the defaults of NewPostgresDatabase are valid
+func TestValidateDefaults(t *testing.T) {
+	db := NewPostgresDatabase("localhost", 5432, "testdb", "user", "pass")
+	if err := db.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+// TestValidateInvalidFields tests that each invalid field is reported by name
+func TestValidateInvalidFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(db *PostgresDatabase)
+		field  string
+	}{
+		{"empty host", func(db *PostgresDatabase) { db.Host = "" }, "Host"},
+		{"port out of range", func(db *PostgresDatabase) { db.Port = 70000 }, "Port"},
+		{"empty database", func(db *PostgresDatabase) { db.Database = "" }, "Database"},
+		{"empty user", func(db *PostgresDatabase) { db.User = "" }, "User"},
+		{"unknown sslmode", func(db *PostgresDatabase) { db.SSLMode = "on" }, "SSLMode"},
+		{"verify-full without CA", func(db *PostgresDatabase) { db.SSLMode = "verify-full" }, "SSLRootCert"},
+		{"zero max open conns", func(db *PostgresDatabase) { db.MaxOpenConns = 0 }, "MaxOpenConns"},
+		{"negative max idle conns", func(db *PostgresDatabase) { db.MaxIdleConns = -1 }, "MaxIdleConns"},
+		{"idle conns above open conns", func(db *PostgresDatabase) { db.MaxIdleConns = 50 }, "MaxIdleConns"},
+		{"negative lifetime", func(db *PostgresDatabase) { db.MaxConnLifetime = -time.Minute }, "MaxConnLifetime"},
+		{"negative idle time", func(db *PostgresDatabase) { db.MaxConnIdleTime = -time.Second }, "MaxConnIdleTime"},
+		{"zero connect timeout", func(db *PostgresDatabase) { db.ConnectTimeout = 0 }, "ConnectTimeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewPostgresDatabase("localhost", 5432, "testdb", "user", "pass")
+			tt.modify(db)
+
+			err := db.Validate()
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if len(validationErr.Errors) != 1 {
+				t.Errorf("Validate() reported %d errors, want 1: %v", len(validationErr.Errors), err)
+			}
+			if validationErr.Field(tt.field) == nil {
+				t.Errorf("Validate() = %v, want an error on %s", err, tt.field)
+			}
+		})
+	}
+}
+
+// TestValidateReportsEveryField tests that all invalid fields are reported at once
+func TestValidateReportsEveryField(t *testing.T) {
+	db := &PostgresDatabase{
+		Port:         5432,
+		MaxOpenConns: 5,
+		MaxIdleConns: 10,
+	}
+
+	err := db.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+
+	for _, field := range []string{"Host", "Database", "User", "MaxIdleConns", "ConnectTimeout"} {
+		if validationErr.Field(field) == nil {
+			t.Errorf("Validate() should report %s", field)
+		}
+		if !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("error message %q should name %s", err, field)
+		}
+	}
+
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Error("errors.As should find a *FieldError in a *ValidationError")
+	}
+}
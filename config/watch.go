@@ -0,0 +1,219 @@
+package config
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change describes a setting whose value differs after a reload. Old and New are
+// rendered as text, and masked for settings tagged secret.
+type Change struct {
+	Key    string // dotted key, e.g. "database.max_open_conns"
+	Secret bool
+	Old    string
+	New    string
+}
+
+// ChangeEvent is published by a Watcher when a reload changes at least one setting
+type ChangeEvent[T any] struct {
+	Old     *T
+	New     *T
+	Changes []Change
+}
+
+// Changed reports whether the setting key, or any setting of the section key, changed
+func (e ChangeEvent[T]) Changed(key string) bool {
+	for _, change := range e.Changes {
+		if change.Key == key || strings.HasPrefix(change.Key, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher reloads a configuration with Load and publishes a ChangeEvent to its
+// subscribers whenever a setting changes. Every source is read again, so files, secret
+// files, .env and secret providers are all picked up; the process environment only
+// changes if the application changes it.
+type Watcher[T any] struct {
+	opts     []LoadOption
+	interval time.Duration
+
+	reloadMu    sync.Mutex // serializes Reload, so that events are published in order
+	mu          sync.Mutex
+	current     *T
+	nextID      int
+	subscribers map[int]func(ChangeEvent[T])
+	onError     func(error)
+}
+
+// NewWatcher loads the configuration once with opts, failing like Load, and returns a
+// Watcher that reloads it every interval once Run is called. Each reload starts from a
+// new T, so defaults come from default tags only.
+func NewWatcher[T any](interval time.Duration, opts ...LoadOption) (*Watcher[T], error) {
+	w := &Watcher[T]{
+		opts:        opts,
+		interval:    interval,
+		subscribers: make(map[int]func(ChangeEvent[T])),
+	}
+	current, err := w.load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	w.current = current
+	return w, nil
+}
+
+// Current returns the last configuration loaded successfully. It must not be modified.
+func (w *Watcher[T]) Current() *T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Subscribe registers fn to be called with each ChangeEvent, in subscription order, from
+// the goroutine calling Reload or Run. The returned function cancels the subscription.
+func (w *Watcher[T]) Subscribe(fn func(ChangeEvent[T])) (unsubscribe func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = fn
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers, id)
+	}
+}
+
+// OnError sets the function called by Run when a reload fails. The previous
+// configuration stays current until a reload succeeds.
+func (w *Watcher[T]) OnError(fn func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onError = fn
+}
+
+// Run reloads the configuration every interval until ctx is done, and returns ctx.Err()
+func (w *Watcher[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := w.Reload(ctx); err != nil {
+				w.mu.Lock()
+				onError := w.onError
+				w.mu.Unlock()
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// Reload loads the configuration now and publishes a ChangeEvent if it changed.
+// On failure, the current configuration is kept and the error returned.
+func (w *Watcher[T]) Reload(ctx context.Context) error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	next, err := w.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	previous := w.current
+	changes := diffSettings(previous, next)
+	if len(changes) > 0 {
+		w.current = next
+	}
+	ids := make([]int, 0, len(w.subscribers))
+	for id := range w.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subscribers := make([]func(ChangeEvent[T]), len(ids))
+	for i, id := range ids {
+		subscribers[i] = w.subscribers[id]
+	}
+	w.mu.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+	event := ChangeEvent[T]{Old: previous, New: next, Changes: changes}
+	for _, fn := range subscribers {
+		fn(event)
+	}
+	return nil
+}
+
+func (w *Watcher[T]) load(ctx context.Context) (*T, error) {
+	cfg := new(T)
+	opts := append(append([]LoadOption(nil), w.opts...), WithContext(ctx))
+	if err := Load(cfg, opts...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// diffSettings lists the settings whose values differ between two configurations
+func diffSettings[T any](previous, next *T) []Change {
+	oldSettings := collectSettings(reflect.ValueOf(previous).Elem(), "", "")
+	newSettings := collectSettings(reflect.ValueOf(next).Elem(), "", "")
+
+	var changes []Change
+	for i, s := range newSettings {
+		if reflect.DeepEqual(oldSettings[i].field.Interface(), s.field.Interface()) {
+			continue
+		}
+		change := Change{Key: s.key, Secret: s.secret, Old: "****", New: "****"}
+		if !s.secret {
+			change.Old = formatField(oldSettings[i].field)
+			change.New = formatField(s.field)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// formatField renders a field in the text form read by setField
+func formatField(field reflect.Value) string {
+	if m, ok := field.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	if d, ok := field.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if field.Kind() == reflect.Slice {
+		items := make([]string, field.Len())
+		for i := range items {
+			items[i] = formatField(field.Index(i))
+		}
+		return strings.Join(items, ",")
+	}
+	if field.Kind() == reflect.Map {
+		pairs := make([]string, 0, field.Len())
+		for _, key := range field.MapKeys() {
+			pairs = append(pairs, formatField(key)+"="+formatField(field.MapIndex(key)))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
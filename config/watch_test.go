@@ -0,0 +1,137 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func rewriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+// watchedConfig is formatted with the number of workers, the secret file and the idle connections
+const watchedConfig = `
+workers: %d
+database:
+  host: localhost
+  name: mapbot
+  user: mapbot
+  password: file://%s
+  max_open_conns: 10
+  max_idle_conns: %d
+`
+
+// TestWatcherReload tests that changes of files and secret files are published once, with secrets masked
+func TestWatcherReload(t *testing.T) {
+	secretFile := writeConfigFile(t, "db_password", "first-password")
+	file := writeConfigFile(t, "service.yaml", "")
+	rewriteFile(t, file, fmt.Sprintf(watchedConfig, 4, secretFile, 5))
+
+	w, err := NewWatcher[serviceConfig](time.Hour, WithFile(file), WithDotEnv(), WithEnvPrefix("WATCH_"))
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	if w.Current().Database.Password != "first-password" {
+		t.Fatalf("Password = %q, want first-password", w.Current().Database.Password)
+	}
+
+	var events []ChangeEvent[serviceConfig]
+	unsubscribe := w.Subscribe(func(event ChangeEvent[serviceConfig]) {
+		events = append(events, event)
+	})
+
+	if err := w.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Reload() without change published %d events", len(events))
+	}
+
+	rewriteFile(t, secretFile, "second-password\n")
+	rewriteFile(t, file, fmt.Sprintf(watchedConfig, 4, secretFile, 2))
+	if err := w.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Reload() published %d events, want 1", len(events))
+	}
+
+	want := []Change{
+		{Key: "database.password", Secret: true, Old: "****", New: "****"},
+		{Key: "database.max_idle_conns", Old: "5", New: "2"},
+	}
+	if !reflect.DeepEqual(events[0].Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", events[0].Changes, want)
+	}
+	if !events[0].Changed("database") || events[0].Changed("workers") {
+		t.Error("Changed() should report the database section only")
+	}
+	if events[0].New.Database.Password != "second-password" || w.Current() != events[0].New {
+		t.Error("the reloaded configuration should be current")
+	}
+
+	// A broken file keeps the current configuration
+	rewriteFile(t, file, "workers: many\n")
+	if err := w.Reload(context.Background()); err == nil {
+		t.Error("Reload() of an invalid file should fail")
+	}
+	if w.Current() != events[0].New {
+		t.Error("a failed reload should keep the current configuration")
+	}
+
+	unsubscribe()
+	rewriteFile(t, file, fmt.Sprintf(watchedConfig, 8, secretFile, 2))
+	if err := w.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(events) != 1 || w.Current().Workers != 8 {
+		t.Errorf("after unsubscribe: %d events, workers %d", len(events), w.Current().Workers)
+	}
+}
+
+// TestWatcherRun tests that Run polls the sources until its context is done
+func TestWatcherRun(t *testing.T) {
+	file := writeConfigFile(t, "service.json", `{"workers": 1, "database": {"host": "h", "name": "n", "user": "u"}}`)
+	w, err := NewWatcher[serviceConfig](10*time.Millisecond, WithFile(file), WithDotEnv(), WithEnvPrefix("WATCH_RUN_"))
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+
+	changed := make(chan int, 10)
+	w.Subscribe(func(event ChangeEvent[serviceConfig]) { changed <- event.New.Workers })
+	failed := make(chan error, 10)
+	w.OnError(func(err error) { failed <- err })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	rewriteFile(t, file, `{"workers": 2, "database": {"host": "h", "name": "n", "user": "u"}}`)
+	select {
+	case workers := <-changed:
+		if workers != 2 {
+			t.Errorf("workers = %d, want 2", workers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not publish the change")
+	}
+
+	rewriteFile(t, file, `{`)
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not report the invalid file")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// credentialsRefreshMargin is how long before their expiry cached credentials are
+// replaced, so that a connection is never attempted with a token about to expire
+const credentialsRefreshMargin = time.Minute
+
+// Credentials authenticate new connections
+type Credentials struct {
+	User      string    // empty keeps the user of the configuration
+	Password  string    // a password or a short-lived token
+	ExpiresAt time.Time // zero if the credentials do not expire
+}
+
+// CredentialsProvider supplies the credentials of new connections, for example IAM
+// authentication tokens
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsProviderFunc adapts a function to the CredentialsProvider interface
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Credentials calls f(ctx)
+func (f CredentialsProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// WithCredentialsProvider is an option to authenticate each new physical connection
+// with credentials from provider instead of the configured password.
+// Credentials are cached until one minute before their ExpiresAt, or for the lifetime
+// of the Manager if they do not expire. If the provider fails while the cached
+// credentials have not expired yet, they are used a while longer.
+func WithCredentialsProvider(provider CredentialsProvider) ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		if provider == nil {
+			return fmt.Errorf("credentials provider cannot be nil")
+		}
+		dm.credentials = &credentialsCache{provider: provider, now: time.Now}
+		return nil
+	})
+}
+
+// credentialsCache caches the credentials of a provider until they are about to expire
+type credentialsCache struct {
+	provider CredentialsProvider
+	now      func() time.Time
+
+	mu      sync.Mutex
+	current *Credentials
+}
+
+// get returns the cached credentials, or new ones if they are about to expire
+func (c *credentialsCache) get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.current != nil && (c.current.ExpiresAt.IsZero() || now.Add(credentialsRefreshMargin).Before(c.current.ExpiresAt)) {
+		return *c.current, nil
+	}
+
+	credentials, err := c.provider.Credentials(ctx)
+	if err != nil {
+		if c.current != nil && now.Before(c.current.ExpiresAt) {
+			return *c.current, nil
+		}
+		return Credentials{}, err
+	}
+	c.current = &credentials
+	return credentials, nil
+}
@@ -0,0 +1,108 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/database"
+	"github.com/pixime-net/mapbot-shared/testutils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// rotatingProvider issues a new password on every call, like a token service, and sets
+// it on the role so that only the latest one is accepted
+type rotatingProvider struct {
+	admin    *pgx.Conn
+	user     string
+	validity time.Duration
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *rotatingProvider) Credentials(ctx context.Context) (database.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	password := fmt.Sprintf("token-%d", p.calls)
+	if _, err := p.admin.Exec(ctx, fmt.Sprintf("ALTER ROLE %s PASSWORD '%s'", p.user, password)); err != nil {
+		return database.Credentials{}, err
+	}
+	return database.Credentials{Password: password, ExpiresAt: time.Now().Add(p.validity)}, nil
+}
+
+func (p *rotatingProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// TestCredentialsProviderRotation tests that new connections of both pools use cached
+// credentials until they are about to expire, then rotated ones
+func TestCredentialsProviderRotation(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	pgContainer, err := testutils.SetupPostGISContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start PostGIS container: %v", err)
+	}
+	t.Cleanup(func() { _ = pgContainer.Terminate(context.Background()) })
+	cfg, err := pgContainer.Config(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get container configuration: %v", err)
+	}
+
+	admin, err := pgx.Connect(ctx, pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = admin.Close(ctx) }()
+
+	// Credentials are refreshed one minute before they expire, so these are cached for 3s
+	provider := &rotatingProvider{admin: admin, user: cfg.User, validity: time.Minute + 3*time.Second}
+	cfg.Password = ""
+	dm, err := database.NewManager(cfg, database.WithCredentialsProvider(provider))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	ping := func() {
+		t.Helper()
+		if err := dm.GetDB().PingContext(ctx); err != nil {
+			t.Errorf("database/sql ping error = %v", err)
+		}
+		if err := dm.GetPool().Ping(ctx); err != nil {
+			t.Errorf("pgxpool ping error = %v", err)
+		}
+	}
+
+	ping()
+	if got := provider.Calls(); got != 1 {
+		t.Errorf("provider called %d times while the credentials are valid, want 1", got)
+	}
+
+	// Once the credentials are about to expire, new connections need token-2
+	time.Sleep(3 * time.Second)
+	dm.GetPool().Reset()
+	dm.GetDB().SetMaxIdleConns(0)
+	dm.GetDB().SetMaxIdleConns(cfg.MaxIdleConns)
+
+	ping()
+	if got := provider.Calls(); got != 2 {
+		t.Errorf("provider called %d times after expiry, want 2", got)
+	}
+
+	stale := *cfg
+	stale.Password = "token-1"
+	if _, err := database.NewManager(&stale); err == nil {
+		t.Error("NewManager() with the expired token should fail")
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeProvider issues a new password on every call, valid for validity (forever if zero)
+type fakeProvider struct {
+	calls    int
+	validity time.Duration
+	now      func() time.Time
+	err      error
+}
+
+func (p *fakeProvider) Credentials(context.Context) (Credentials, error) {
+	p.calls++
+	if p.err != nil {
+		return Credentials{}, p.err
+	}
+	credentials := Credentials{Password: fmt.Sprintf("token-%d", p.calls)}
+	if p.validity > 0 {
+		credentials.ExpiresAt = p.now().Add(p.validity)
+	}
+	return credentials, nil
+}
+
+// TestCredentialsCache tests caching until shortly before expiry and the fallback on provider errors
+func TestCredentialsCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	provider := &fakeProvider{validity: 15 * time.Minute, now: clock}
+	cache := &credentialsCache{provider: provider, now: clock}
+	ctx := context.Background()
+
+	password := func() string {
+		t.Helper()
+		credentials, err := cache.get(ctx)
+		if err != nil {
+			t.Fatalf("get() error = %v", err)
+		}
+		return credentials.Password
+	}
+
+	if got := password(); got != "token-1" {
+		t.Errorf("first get() = %s, want token-1", got)
+	}
+	now = now.Add(13 * time.Minute)
+	if got := password(); got != "token-1" {
+		t.Errorf("get() before expiry = %s, want the cached token-1", got)
+	}
+	now = now.Add(90 * time.Second)
+	if got := password(); got != "token-2" {
+		t.Errorf("get() within the refresh margin = %s, want token-2", got)
+	}
+
+	// A failing provider is tolerated while the cached token is still valid
+	provider.err = errors.New("token service unavailable")
+	now = now.Add(14*time.Minute + 30*time.Second)
+	if got := password(); got != "token-2" {
+		t.Errorf("get() with a failing provider = %s, want the still valid token-2", got)
+	}
+	now = now.Add(time.Minute)
+	if _, err := cache.get(ctx); err == nil {
+		t.Error("get() with a failing provider and an expired token should fail")
+	}
+
+	provider.err = nil
+	if got := password(); got != "token-5" {
+		t.Errorf("get() after recovery = %s, want token-5", got)
+	}
+}
+
+// TestCredentialsCacheWithoutExpiry tests that credentials without expiry are fetched once
+func TestCredentialsCacheWithoutExpiry(t *testing.T) {
+	provider := &fakeProvider{now: time.Now}
+	cache := &credentialsCache{provider: provider, now: time.Now}
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.get(context.Background()); err != nil {
+			t.Fatalf("get() error = %v", err)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %d times, want 1", provider.calls)
+	}
+}
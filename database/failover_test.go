@@ -0,0 +1,131 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pixime-net/mapbot-shared/config"
+	"github.com/pixime-net/mapbot-shared/database"
+	"github.com/pixime-net/mapbot-shared/testutils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// startMarkedContainer starts a PostGIS container holding a table that names it
+func startMarkedContainer(t *testing.T, ctx context.Context, name string) *testutils.PostgresContainer {
+	t.Helper()
+
+	pgContainer, err := testutils.SetupPostGISContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start PostGIS container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(context.Background()); err != nil {
+			t.Logf("Failed to terminate container: %v", err)
+		}
+	})
+
+	conn, err := pgx.Connect(ctx, pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("Failed to connect to container %s: %v", name, err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+	if _, err := conn.Exec(ctx, "CREATE TABLE failover_marker AS SELECT $1::text AS name", name); err != nil {
+		t.Fatalf("Failed to mark container %s: %v", name, err)
+	}
+	return pgContainer
+}
+
+// setReadOnly makes new sessions of the container read-only, as on a standby
+func setReadOnly(t *testing.T, ctx context.Context, pgContainer *testutils.PostgresContainer) {
+	t.Helper()
+
+	conn, err := pgx.Connect(ctx, pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("Failed to connect to container: %v", err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+	for _, sql := range []string{
+		"ALTER SYSTEM SET default_transaction_read_only = on",
+		"SELECT pg_reload_conf()",
+	} {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+}
+
+// connectedMarker returns the marker of the server both pools of a new Manager are connected to
+func connectedMarker(t *testing.T, ctx context.Context, cfg *config.PostgresDatabase) string {
+	t.Helper()
+
+	dm, err := database.NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	var fromDB, fromPool string
+	if err := dm.GetDB().QueryRowContext(ctx, "SELECT name FROM failover_marker").Scan(&fromDB); err != nil {
+		t.Fatalf("database/sql query error = %v", err)
+	}
+	if err := dm.GetPool().QueryRow(ctx, "SELECT name FROM failover_marker").Scan(&fromPool); err != nil {
+		t.Fatalf("pgxpool query error = %v", err)
+	}
+	if fromDB != fromPool {
+		t.Fatalf("database/sql is connected to %s but pgxpool to %s", fromDB, fromPool)
+	}
+	return fromDB
+}
+
+// TestMultiHostFailover tests host selection against two containers, the first of which
+// is read-only and finally stopped
+func TestMultiHostFailover(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	first := startMarkedContainer(t, ctx, "first")
+	second := startMarkedContainer(t, ctx, "second")
+	setReadOnly(t, ctx, first)
+
+	newConfig := func(targetSessionAttrs string) *config.PostgresDatabase {
+		cfg, err := first.Config(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get container configuration: %v", err)
+		}
+		secondCfg, err := second.Config(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get container configuration: %v", err)
+		}
+		cfg.FallbackHosts = []config.HostPort{{Host: secondCfg.Host, Port: secondCfg.Port}}
+		cfg.TargetSessionAttrs = targetSessionAttrs
+		return cfg
+	}
+
+	tests := []struct {
+		targetSessionAttrs string
+		want               string
+	}{
+		{"", "first"},
+		{"read-only", "first"},
+		{"read-write", "second"},
+		{"primary", "second"},
+	}
+	for _, tt := range tests {
+		t.Run("target_session_attrs="+tt.targetSessionAttrs, func(t *testing.T) {
+			if got := connectedMarker(t, ctx, newConfig(tt.targetSessionAttrs)); got != tt.want {
+				t.Errorf("connected to %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("first host down", func(t *testing.T) {
+		if err := first.Stop(ctx, nil); err != nil {
+			t.Fatalf("Failed to stop the first container: %v", err)
+		}
+		if got := connectedMarker(t, ctx, newConfig("")); got != "second" {
+			t.Errorf("connected to %s, want second", got)
+		}
+	})
+}
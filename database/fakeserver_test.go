@@ -0,0 +1,180 @@
+package database
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/config"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// fakeServer speaks just enough of the PostgreSQL protocol to accept connections and
+// run statements without arguments. reject returns the SQLSTATE that refuses the nth
+// connection, counted from 1, or "" to accept it.
+type fakeServer struct {
+	listener    net.Listener
+	connections atomic.Int32
+	reject      func(n int32) string
+
+	mu      sync.Mutex
+	queries []string
+	fail    func(query string) string   // SQLSTATE failing a statement, "" to run it
+	row     func(query string) []string // float8 row returned by a statement, nil for none
+}
+
+func startFakeServer(t *testing.T, reject func(n int32) string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeServer{listener: listener, reject: reject}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config returns the configuration of a Manager connecting to s
+func (s *fakeServer) config() *config.PostgresDatabase {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	cfg := config.NewPostgresDatabase(addr.IP.String(), addr.Port, "mapbot", "mapbot", "secret")
+	cfg.MaxIdleConns = 0 // no connection in the background
+	cfg.ConnectTimeout = time.Second
+	return cfg
+}
+
+// failWith makes the statements for which fail returns a SQLSTATE fail
+func (s *fakeServer) failWith(fail func(query string) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// rowsWith makes the statements for which row returns values return them as a row of
+// float8 columns, t and f being booleans and NULL a null
+func (s *fakeServer) rowsWith(row func(query string) []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.row = row
+}
+
+// statements returns the statements run so far, pings excluded, and forgets them
+func (s *fakeServer) statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := s.queries
+	s.queries = nil
+	return queries
+}
+
+// run records query and returns the SQLSTATE of its failure, if any, or its row
+func (s *fakeServer) run(query string) (code string, row []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, query)
+	if s.fail != nil {
+		code = s.fail(query)
+	}
+	if s.row != nil {
+		row = s.row(query)
+	}
+	return code, row
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	if code := s.reject(s.connections.Add(1)); code != "" {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: code, Message: "rejected by the fake server"})
+		_ = backend.Flush()
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := backend.Flush(); err != nil {
+		return
+	}
+	txStatus := byte('I')
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			txStatus = s.query(backend, msg.String, txStatus)
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+			if err := backend.Flush(); err != nil {
+				return
+			}
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+// query answers a simple query and returns the transaction status that follows it
+func (s *fakeServer) query(backend *pgproto3.Backend, query string, txStatus byte) byte {
+	if strings.HasPrefix(query, "--") { // ping
+		backend.Send(&pgproto3.EmptyQueryResponse{})
+		return txStatus
+	}
+	command := strings.ToUpper(strings.Fields(query)[0])
+	code, row := s.run(query)
+	if code != "" {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: code, Message: "failed by the fake server"})
+		if txStatus == 'I' || command == "COMMIT" {
+			return 'I'
+		}
+		return 'E'
+	}
+	if row != nil {
+		fields := make([]pgproto3.FieldDescription, len(row))
+		values := make([][]byte, len(row))
+		for i, value := range row {
+			fields[i] = pgproto3.FieldDescription{Name: []byte("?column?"), DataTypeOID: 701, DataTypeSize: 8, TypeModifier: -1}
+			switch value {
+			case "t", "f":
+				fields[i].DataTypeOID, fields[i].DataTypeSize = 16, 1
+				values[i] = []byte(value)
+			case "NULL":
+			default:
+				values[i] = []byte(value)
+			}
+		}
+		backend.Send(&pgproto3.RowDescription{Fields: fields})
+		backend.Send(&pgproto3.DataRow{Values: values})
+		backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+		return txStatus
+	}
+	switch command {
+	case "BEGIN":
+		txStatus = 'T'
+	case "COMMIT", "ROLLBACK":
+		if command == "COMMIT" && txStatus == 'E' {
+			command = "ROLLBACK"
+		}
+		txStatus = 'I'
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(command)})
+	return txStatus
+}
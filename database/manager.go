@@ -3,85 +3,235 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pixime-net/mapbot-shared/config"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	// Import the pgx driver for database/sql
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 // Manager manages connections to the database and provides utility methods for health checks and stats
 type Manager struct {
-	db     *sql.DB
-	config *config.PostgresDatabase
-	pool   *pgxpool.Pool
+	db *sql.DB
+
+	mu         sync.RWMutex
+	config     *config.PostgresDatabase
+	pool       *pgxpool.Pool
+	connConfig *pgx.ConnConfig // connection settings of new connections, see beforeConnect
+	generation int64           // incremented whenever connConfig changes
+
+	credentials *credentialsCache // set by WithCredentialsProvider
+	dualPools   bool              // set by WithDualPools
+	retry       *RetryPolicy      // set by WithStartupRetry
+	onConnected []OptionFunc      // added by connect options, run once connected
+	onClose     []func()          // added by options, run by Close
+	tracers     []pgx.QueryTracer // added by the options that trace queries
+	tracer      pgx.QueryTracer   // combination of tracers, set on every connection
+	connecting  bool              // set once the connect options are applied
+
+	replicas     []*replica     // set by WithReplicas
+	replicaOpts  ReplicaOptions // set by WithReplicas
+	nextReplica  atomic.Uint64  // round robin of Reader
+	stopReplicas context.CancelFunc
+	replicasDone chan struct{} // closed when the health checks stop
 }
 
-// ManagerOption is a configuration function
-type ManagerOption func(*Manager) error
+// generationKey is the pgconn custom data key holding the generation of a connection
+const generationKey = "mapbot.generation"
+
+// ManagerOption configures a Manager in two phases of NewManagerContext, both given its
+// context: BeforeConnect, for the options changing how connections are made, such as
+// WithCredentialsProvider, WithStartupRetry, WithTracing or WithDualPools, and then
+// AfterConnect once the manager is connected, when GetDB and GetPool are usable. Each
+// phase runs the options in order. Custom options are usually OptionFunc.
+type ManagerOption interface {
+	BeforeConnect(ctx context.Context, dm *Manager) error
+	AfterConnect(ctx context.Context, dm *Manager) error
+}
+
+// OptionFunc is a ManagerOption applied once the manager is connected
+type OptionFunc func(ctx context.Context, dm *Manager) error
+
+// BeforeConnect does nothing
+func (f OptionFunc) BeforeConnect(context.Context, *Manager) error {
+	return nil
+}
+
+// AfterConnect calls f
+func (f OptionFunc) AfterConnect(ctx context.Context, dm *Manager) error {
+	return f(ctx, dm)
+}
+
+// connectOption is a ManagerOption changing how connections are made, applied before
+// connecting
+type connectOption func(ctx context.Context, dm *Manager) error
+
+// BeforeConnect calls o, and fails once NewManagerContext started connecting, when o
+// would have no effect, such as when another option calls it from its AfterConnect
+func (o connectOption) BeforeConnect(ctx context.Context, dm *Manager) error {
+	if dm.connecting {
+		return fmt.Errorf("an option changing how connections are made was applied after connecting, call its BeforeConnect method before connecting")
+	}
+	return o(ctx, dm)
+}
+
+// AfterConnect does nothing
+func (o connectOption) AfterConnect(context.Context, *Manager) error {
+	return nil
+}
 
 // WithMigrations is an option to automatically run migrations
 func WithMigrations(migrationsPath string) ManagerOption {
-	return func(dm *Manager) error {
-		return RunMigrations(dm.db, migrationsPath, "public", "schema_migrations")
-	}
+	return WithMigrationsCustomSchema(migrationsPath, "public", "schema_migrations")
 }
 
-// WithMigrationsCustomSchema is an option to run migrations with custom schema and table
+// WithMigrationsCustomSchema is an option to run migrations with custom schema and table.
+// When the context of NewManagerContext is done, the remaining migrations are skipped.
 func WithMigrationsCustomSchema(migrationsPath, schemaName, tableName string) ManagerOption {
-	return func(dm *Manager) error {
-		return RunMigrations(dm.db, migrationsPath, schemaName, tableName)
-	}
+	return OptionFunc(func(ctx context.Context, dm *Manager) error {
+		return RunMigrationsContext(ctx, dm.db, migrationsPath, schemaName, tableName)
+	})
 }
 
-// NewManager creates a new database manager
+// NewManager creates a new database manager, see NewManagerContext
 func NewManager(cfg *config.PostgresDatabase, opts ...ManagerOption) (*Manager, error) {
+	return NewManagerContext(context.Background(), cfg, opts...)
+}
+
+// NewManagerContext creates a new database manager. Every step observes ctx: the pool
+// creation, the connection check and the options such as migrations, so that a
+// shutdown signal during startup stops it.
+// The configuration is checked with Validate first, so an invalid configuration is
+// reported as a *config.ValidationError. With FallbackHosts, every new connection tries
+// the hosts in order, keeping the first that matches TargetSessionAttrs.
+//
+// GetDB and GetPool share a single pgxpool, so MaxOpenConns bounds the connections of
+// both, unless the legacy WithDualPools option is given.
+func NewManagerContext(ctx context.Context, cfg *config.PostgresDatabase, opts ...ManagerOption) (*Manager, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("database config cannot be nil")
 	}
 
-	// Validation of required fields
-	if cfg.Host == "" {
-		return nil, fmt.Errorf("host cannot be empty")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
-	if cfg.Database == "" {
-		return nil, fmt.Errorf("database name cannot be empty")
+
+	poolConfig, err := newPoolConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.User == "" {
-		return nil, fmt.Errorf("user cannot be empty")
+
+	dm := &Manager{
+		config:     cfg,
+		connConfig: poolConfig.ConnConfig.Copy(),
 	}
 
-	connectionString := cfg.ConnectionString()
-	db, err := sql.Open("pgx", connectionString)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+	// Apply the options changing how connections are made
+	for _, opt := range opts {
+		if opt == nil {
+			return nil, fmt.Errorf("database option cannot be nil")
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := opt.BeforeConnect(ctx, dm); err != nil {
+			return nil, fmt.Errorf("failed to apply database option: %w", err)
+		}
 	}
 
-	// Configure the connection pool
-	db.SetMaxOpenConns(cfg.MaxOpenConns)
-	db.SetMaxIdleConns(cfg.MaxIdleConns)
-	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Minute)
-	db.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime) * time.Second)
+	dm.tracer = combineTracers(dm.tracers)
+	dm.connConfig.Tracer = dm.tracer
+	dm.connecting = true
+
+	if dm.dualPools {
+		dm.db = stdlib.OpenDB(*poolConfig.ConnConfig.Copy(),
+			stdlib.OptionBeforeConnect(dm.beforeConnect),
+			stdlib.OptionResetSession(dm.resetSession),
+		)
+		configureDB(dm.db, cfg)
+	} else {
+		pool, err := dm.newPool(ctx, poolConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error creating connection pool: %w", err)
+		}
+		dm.pool = pool
+		dm.db = openSharedDB(dm)
+	}
 
 	// Test the connection with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.PingTimeout)*time.Second)
-	defer cancel()
+	if err := dm.connect(ctx, cfg.EffectiveConnectTimeout()); err != nil {
+		timeout := cfg.EffectiveConnectTimeout()
+		if dm.retry != nil {
+			timeout = dm.retry.Timeout
+		}
+		_ = dm.Close()
+		return nil, fmt.Errorf("failed to connect to database %s within %s: %w",
+			cfg.PublicConnectionString(), timeout, err)
+	}
+
+	if dm.dualPools {
+		pool, err := dm.newPool(ctx, poolConfig)
+		if err != nil {
+			_ = dm.db.Close()
+			return nil, fmt.Errorf("error creating connection pool: %w", err)
+		}
+		dm.pool = pool
+	}
+
+	for _, step := range dm.onConnected {
+		if err := step(ctx, dm); err != nil {
+			_ = dm.Close()
+			return nil, fmt.Errorf("failed to apply database option: %w", err)
+		}
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		err := ctx.Err()
+		if err == nil {
+			err = opt.AfterConnect(ctx, dm)
+		}
+		if err != nil {
+			_ = dm.Close()
+			return nil, fmt.Errorf("failed to apply database option: %w", err)
+		}
+	}
 
-	if err := db.PingContext(ctx); err != nil {
-		_ = db.Close()
-		return nil, fmt.Errorf("failed to connect to database %s within %ds: %w",
-			cfg.PublicConnectionString(), cfg.PingTimeout, err)
+	return dm, nil
+}
+
+// combineTracers returns a tracer calling all of tracers, nil if there is none
+func combineTracers(tracers []pgx.QueryTracer) pgx.QueryTracer {
+	switch len(tracers) {
+	case 0:
+		return nil
+	case 1:
+		return tracers[0]
+	default:
+		return multitracer.New(tracers...)
 	}
+}
 
-	// Create pgxpool for advanced features (batch operations, better performance)
-	poolConfig, err := pgxpool.ParseConfig(connectionString)
+// newPoolConfig parses the configuration once for both APIs: pgxpool strips its pool_*
+// parameters, which would otherwise be sent to the server as runtime parameters by the
+// database/sql driver. ssl_min_protocol_version, unknown to pgx, is removed the same way
+// and applied by configureTLS.
+func newPoolConfig(cfg *config.PostgresDatabase) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.ConnectionString())
 	if err != nil {
-		_ = db.Close()
 		return nil, fmt.Errorf("error parsing pool config: %w", err)
 	}
+	delete(poolConfig.ConnConfig.RuntimeParams, "ssl_min_protocol_version")
+	if err := configureTLS(poolConfig.ConnConfig, cfg); err != nil {
+		return nil, fmt.Errorf("error configuring TLS: %w", err)
+	}
 
 	// Configure pool with similar settings
 	// Cap values to int32 max to avoid overflow
@@ -95,42 +245,135 @@ func NewManager(cfg *config.PostgresDatabase, opts ...ManagerOption) (*Manager,
 	}
 	poolConfig.MaxConns = int32(maxConns) // #nosec G115 -- values are capped to prevent overflow
 	poolConfig.MinConns = int32(minConns) // #nosec G115 -- values are capped to prevent overflow
+	// Zero would expire pgxpool connections immediately, keep the pgxpool defaults instead
+	if lifetime := cfg.EffectiveMaxConnLifetime(); lifetime > 0 {
+		poolConfig.MaxConnLifetime = lifetime
+	}
+	if idleTime := cfg.EffectiveMaxConnIdleTime(); idleTime > 0 {
+		poolConfig.MaxConnIdleTime = idleTime
+	}
+	return poolConfig, nil
+}
+
+// newPool creates a pgxpool whose connections follow the connection settings of dm
+func (dm *Manager) newPool(ctx context.Context, poolConfig *pgxpool.Config) (*pgxpool.Pool, error) {
+	poolConfig.ConnConfig.Tracer = dm.tracer
+	poolConfig.BeforeConnect = dm.beforeConnect
+	poolConfig.PrepareConn = func(_ context.Context, conn *pgx.Conn) (bool, error) {
+		return !dm.isStale(conn), nil
+	}
+	poolConfig.AfterRelease = func(conn *pgx.Conn) bool {
+		return !dm.isStale(conn)
+	}
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// configureDB applies the pool settings of cfg to db, which takes effect immediately
+func configureDB(db *sql.DB, cfg *config.PostgresDatabase) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.EffectiveMaxConnLifetime())
+	db.SetConnMaxIdleTime(cfg.EffectiveMaxConnIdleTime())
+}
+
+// beforeConnect makes every new connection use the current connection
+// settings and credentials, and tags it with the generation of the settings
+func (dm *Manager) beforeConnect(ctx context.Context, connConfig *pgx.ConnConfig) error {
+	dm.mu.RLock()
+	current, generation := dm.connConfig, dm.generation
+	dm.mu.RUnlock()
+
+	*connConfig = *current.Copy()
+	if err := dm.applyCredentials(ctx, connConfig); err != nil {
+		return err
+	}
+	afterConnect := connConfig.AfterConnect
+	connConfig.AfterConnect = func(ctx context.Context, pgConn *pgconn.PgConn) error {
+		pgConn.CustomData()[generationKey] = generation
+		if afterConnect != nil {
+			return afterConnect(ctx, pgConn)
+		}
+		return nil
+	}
+	return nil
+}
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+// applyCredentials sets the credentials of WithCredentialsProvider in connConfig, if any
+func (dm *Manager) applyCredentials(ctx context.Context, connConfig *pgx.ConnConfig) error {
+	if dm.credentials == nil {
+		return nil
+	}
+	credentials, err := dm.credentials.get(ctx)
 	if err != nil {
-		_ = db.Close()
-		return nil, fmt.Errorf("error creating connection pool: %w", err)
+		return fmt.Errorf("failed to get database credentials: %w", err)
 	}
+	if credentials.User != "" {
+		connConfig.User = credentials.User
+	}
+	connConfig.Password = credentials.Password
+	return nil
+}
 
-	dm := &Manager{
-		db:     db,
-		config: cfg,
-		pool:   pool,
+// isStale reports whether conn was opened with connection settings that have changed since
+func (dm *Manager) isStale(conn *pgx.Conn) bool {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return conn.PgConn().CustomData()[generationKey] != dm.generation
+}
+
+// resetSession discards stale connections of the database/sql pool before their reuse;
+// connections borrowed from the pgxpool are already checked by its PrepareConn
+func (dm *Manager) resetSession(_ context.Context, conn *pgx.Conn) error {
+	if dm.isStale(conn) {
+		return driver.ErrBadConn
 	}
+	return nil
+}
 
-	// Apply options
-	for _, opt := range opts {
-		if err := opt(dm); err != nil {
-			_ = dm.Close()
-			return nil, fmt.Errorf("failed to apply database option: %w", err)
+// configureTLS replaces the TLS settings pgx derived from the connection string with
+// cfg.TLSConfig, which also covers in-memory PEM, SSLServerName and SSLMinProtocolVersion.
+// Connection attempts without TLS (sslmode disable, or the plain fallback of allow and
+// prefer) are left untouched.
+func configureTLS(connConfig *pgx.ConnConfig, cfg *config.PostgresDatabase) error {
+	if connConfig.TLSConfig != nil {
+		tlsConfig, err := cfg.TLSConfig(connConfig.Host)
+		if err != nil {
+			return err
 		}
+		connConfig.TLSConfig = tlsConfig
 	}
-
-	return dm, nil
+	for _, fallback := range connConfig.Fallbacks {
+		if fallback.TLSConfig == nil {
+			continue
+		}
+		tlsConfig, err := cfg.TLSConfig(fallback.Host)
+		if err != nil {
+			return err
+		}
+		fallback.TLSConfig = tlsConfig
+	}
+	return nil
 }
 
-// GetDB returns the *sql.DB instance
+// GetDB returns the *sql.DB instance. Unless WithDualPools is given, it borrows its
+// connections from the pgxpool of GetPool and keeps working when ApplyConfig replaces it.
 func (dm *Manager) GetDB() *sql.DB {
 	return dm.db
 }
 
-// GetPool returns the *pgxpool.Pool instance for advanced operations
+// GetPool returns the *pgxpool.Pool instance for advanced operations.
+// ApplyConfig replaces the pool when its size changes, so callers that apply
+// configuration changes should call GetPool for each operation rather than keep the pool.
 func (dm *Manager) GetPool() *pgxpool.Pool {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
 	return dm.pool
 }
 
 // GetConfig returns the database configuration
 func (dm *Manager) GetConfig() *config.PostgresDatabase {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
 	return dm.config
 }
 
@@ -139,24 +382,29 @@ func (dm *Manager) Ping(ctx context.Context) error {
 	return dm.db.PingContext(ctx)
 }
 
-// Stats returns the connection pool statistics
+// Stats returns the connection pool statistics: those of the shared pgxpool, or of
+// the database/sql pool with WithDualPools
 func (dm *Manager) Stats() sql.DBStats {
-	return dm.db.Stats()
+	if dm.dualPools {
+		return dm.db.Stats()
+	}
+	return poolStats(dm.GetPool())
 }
 
 // Close closes all connections
 func (dm *Manager) Close() error {
-	var dbErr, poolErr error
-	if dm.pool != nil {
-		dm.pool.Close()
-	}
+	var dbErr error
+	dm.closeReplicas()
 	if dm.db != nil {
 		dbErr = dm.db.Close()
 	}
-	if dbErr != nil {
-		return dbErr
+	if pool := dm.GetPool(); pool != nil {
+		pool.Close()
+	}
+	for _, step := range dm.onClose {
+		step()
 	}
-	return poolErr
+	return dbErr
 }
 
 // Health checks the health status of the database
@@ -166,9 +414,9 @@ func (dm *Manager) Health(ctx context.Context) error {
 	}
 
 	stats := dm.Stats()
-	if stats.OpenConnections >= dm.config.MaxOpenConns {
+	if maxOpenConns := dm.GetConfig().MaxOpenConns; stats.OpenConnections >= maxOpenConns {
 		return fmt.Errorf("database connection pool exhausted: %d/%d connections",
-			stats.OpenConnections, dm.config.MaxOpenConns)
+			stats.OpenConnections, maxOpenConns)
 	}
 
 	return nil
@@ -176,5 +424,5 @@ func (dm *Manager) Health(ctx context.Context) error {
 
 // PublicConnectionString returns the configuration information as a string without the password
 func (dm *Manager) PublicConnectionString() string {
-	return dm.config.PublicConnectionString()
+	return dm.GetConfig().PublicConnectionString()
 }
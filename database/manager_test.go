@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/config"
+)
+
+// contextKey marks the context given to NewManagerContext
+type contextKey struct{}
+
+// TestNewManagerContext tests that options observe the context
+func TestNewManagerContext(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	ctx := context.WithValue(context.Background(), contextKey{}, "startup")
+
+	var optionCtx, stepCtx context.Context
+	dm, err := NewManagerContext(ctx, server.config(),
+		OptionFunc(func(ctx context.Context, _ *Manager) error {
+			optionCtx = ctx
+			return nil
+		}),
+		OptionFunc(func(ctx context.Context, dm *Manager) error {
+			stepCtx = ctx
+			return dm.Ping(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewManagerContext() error = %v", err)
+	}
+	_ = dm.Close()
+	if optionCtx == nil || optionCtx.Value(contextKey{}) != "startup" {
+		t.Error("the option was not given the context")
+	}
+	if stepCtx == nil || stepCtx.Value(contextKey{}) != "startup" {
+		t.Error("the second option was not given the context")
+	}
+
+	errStep := errors.New("step failed")
+	_, err = NewManagerContext(ctx, server.config(), OptionFunc(func(context.Context, *Manager) error {
+		return errStep
+	}))
+	if !errors.Is(err, errStep) {
+		t.Errorf("NewManagerContext() error = %v, want the step error", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	connections := server.connections.Load()
+	_, err = NewManagerContext(canceled, server.config(), OptionFunc(func(context.Context, *Manager) error {
+		t.Error("the option should not run once the context is canceled")
+		return nil
+	}))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("NewManagerContext() error = %v, want context.Canceled", err)
+	}
+	if got := server.connections.Load(); got != connections {
+		t.Errorf("%d connections made with a canceled context", got-connections)
+	}
+}
+
+// TestManagerOptionOrder tests that options run once connected, after the options
+// changing how connections are made whatever their position
+func TestManagerOptionOrder(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	provider, exporter := newTestProvider(t)
+
+	dm, err := NewManager(server.config(),
+		OptionFunc(func(ctx context.Context, dm *Manager) error {
+			if err := dm.GetDB().PingContext(ctx); err != nil {
+				return err
+			}
+			_, err := dm.GetPool().Exec(ctx, "UPDATE tiles SET z = 3")
+			return err
+		}),
+		WithTracing(provider),
+	)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	_ = dm.Close()
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Name != "UPDATE" {
+		t.Errorf("the query of the option was not traced: %d spans", len(spans))
+	}
+
+	// A connect option wrapped in an option applied once connected would have no effect
+	tracing := WithTracing(provider)
+	_, err = NewManager(server.config(), OptionFunc(func(ctx context.Context, dm *Manager) error {
+		return tracing.BeforeConnect(ctx, dm)
+	}))
+	if err == nil {
+		t.Error("NewManager() should refuse a connect option applied after connecting")
+	}
+}
+
+// TestApplyConfigResizesPool tests that ApplyConfig applies new pool sizes live, with
+// the new connection settings, and drains the previous pool
+func TestApplyConfigResizesPool(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	dm, err := NewManager(server.config())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+	ctx := context.Background()
+	old := dm.GetPool()
+	held, err := old.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	next := *dm.GetConfig()
+	next.ApplicationName = "mapbot-tiles"
+	next.MaxOpenConns = 8
+	if err := dm.ApplyConfig(&next); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	if got := dm.GetPool().Stat().MaxConns(); got != 8 {
+		t.Errorf("pool MaxConns after ApplyConfig = %d, want 8", got)
+	}
+	if got := dm.Stats().MaxOpenConnections; got != 8 {
+		t.Errorf("Stats().MaxOpenConnections = %d, want 8", got)
+	}
+	if got := dm.GetConfig().ApplicationName; got != "mapbot-tiles" {
+		t.Errorf("GetConfig().ApplicationName = %q", got)
+	}
+	if err := dm.GetDB().PingContext(ctx); err != nil {
+		t.Errorf("database/sql Ping() after ApplyConfig error = %v", err)
+	}
+
+	// The previous pool lets its acquired connections finish, then closes
+	if _, err := held.Exec(ctx, "UPDATE tiles SET z = 3"); err != nil {
+		t.Errorf("query on a connection of the previous pool error = %v", err)
+	}
+	held.Release()
+	for deadline := time.Now().Add(time.Second); old.Stat().TotalConns() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := old.Stat().TotalConns(); got != 0 {
+		t.Errorf("previous pool still has %d connections", got)
+	}
+}
+
+// TestApplyConfigResizesDualPools tests that ApplyConfig resizes both pools of WithDualPools
+func TestApplyConfigResizesDualPools(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	dm, err := NewManager(server.config(), WithDualPools())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	next := *dm.GetConfig()
+	next.MaxOpenConns = 8
+	if err := dm.ApplyConfig(&next); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	if got := dm.GetDB().Stats().MaxOpenConnections; got != 8 {
+		t.Errorf("database/sql MaxOpenConnections = %d, want 8", got)
+	}
+	if got := dm.GetPool().Stat().MaxConns(); got != 8 {
+		t.Errorf("pool MaxConns = %d, want 8", got)
+	}
+}
+
+// TestNewPoolConfigTLSParameters tests that ssl_min_protocol_version is not sent to the
+// server as a runtime parameter
+func TestNewPoolConfigTLSParameters(t *testing.T) {
+	cfg := config.NewPostgresDatabase("db.example.com", 5432, "mapbot", "mapbot", "secret")
+	cfg.SSLMode = "require"
+	cfg.SSLMinProtocolVersion = "TLSv1.3"
+	poolConfig, err := newPoolConfig(cfg)
+	if err != nil {
+		t.Fatalf("newPoolConfig() error = %v", err)
+	}
+	if value, ok := poolConfig.ConnConfig.RuntimeParams["ssl_min_protocol_version"]; ok {
+		t.Errorf("runtime parameter ssl_min_protocol_version = %q", value)
+	}
+	if tlsConfig := poolConfig.ConnConfig.TLSConfig; tlsConfig == nil || tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLS config = %+v, want TLS 1.3 at least", tlsConfig)
+	}
+}
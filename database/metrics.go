@@ -0,0 +1,405 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the query latency histogram
+// buckets when NewMetrics is given none
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the statistics of the pools and queries of managers, and exposes them
+// in the Prometheus text format. A Metrics is shared by the managers of a process, each
+// added with WithMetrics, and served by Handler:
+//
+//	metrics := database.NewMetrics()
+//	dm, err := database.NewManager(cfg, database.WithMetrics(metrics))
+//	http.Handle("/metrics", metrics.Handler())
+type Metrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	managers []registeredManager
+	lastID   int // manager label of the last registered manager
+	queries  map[queryKey]*queryStats
+}
+
+// registeredManager is a manager whose pools are collected, and its labels
+type registeredManager struct {
+	dm       *Manager
+	database string
+	id       string
+}
+
+// queryKey identifies the series of the query metrics
+type queryKey struct {
+	database, operation string
+}
+
+// queryStats are the counts and latency histogram of a kind of query
+type queryStats struct {
+	ok, errors uint64
+	buckets    []uint64 // counts per bucket, not cumulated
+	sum        float64
+}
+
+// NewMetrics creates an empty collector, with the latency histogram buckets given in
+// seconds, DefaultLatencyBuckets if none
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Metrics{buckets: buckets, queries: make(map[queryKey]*queryStats)}
+}
+
+// WithMetrics is an option to publish the statistics of the manager pools, including
+// those of its replicas, and of its queries through m. Queries are counted and timed by
+// operation (SELECT, INSERT, ...) whether they are run through GetPool, GetDB, Reader or
+// ReaderDB. Series are labelled with the database name, and pool series also with a
+// manager number, unique within m, telling apart the managers of the same database.
+// Their queries are counted together. Close removes the pools of the manager from m.
+func WithMetrics(m *Metrics) ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		if m == nil {
+			return fmt.Errorf("metrics cannot be nil")
+		}
+		database := dm.config.Database
+		dm.tracers = append(dm.tracers, &metricsTracer{metrics: m, database: database})
+		dm.onConnected = append(dm.onConnected, func(_ context.Context, dm *Manager) error {
+			m.register(dm, database)
+			return nil
+		})
+		dm.onClose = append(dm.onClose, func() { m.unregister(dm) })
+		return nil
+	})
+}
+
+// register adds the pools of dm to the collected statistics under the next manager number
+func (m *Metrics) register(dm *Manager, database string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	m.managers = append(m.managers, registeredManager{dm: dm, database: database, id: strconv.Itoa(m.lastID)})
+}
+
+// unregister removes the pools of dm from the collected statistics, if registered. The
+// query statistics of its database are kept, as counters must not decrease.
+func (m *Metrics) unregister(dm *Manager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.managers = slices.DeleteFunc(m.managers, func(r registeredManager) bool { return r.dm == dm })
+}
+
+// observe records a query that took duration and failed with err, if not nil
+func (m *Metrics) observe(database, operation string, duration time.Duration, err error) {
+	seconds := duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := queryKey{database: database, operation: operation}
+	stats := m.queries[key]
+	if stats == nil {
+		stats = &queryStats{buckets: make([]uint64, len(m.buckets))}
+		m.queries[key] = stats
+	}
+	if err != nil {
+		stats.errors++
+	} else {
+		stats.ok++
+	}
+	stats.sum += seconds
+	if i, _ := slices.BinarySearch(m.buckets, seconds); i < len(m.buckets) {
+		stats.buckets[i]++
+	}
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = m.WriteTo(w)
+	})
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	out := bufio.NewWriter(counter)
+	for _, f := range m.collect() {
+		if err := f.write(out); err != nil {
+			return counter.n, err
+		}
+	}
+	err := out.Flush()
+	return counter.n, err
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family is a metric and its samples
+type family struct {
+	name, help, kind string
+	samples          []sample
+}
+
+// sample is a value of a family, suffix distinguishing the series of histograms
+type sample struct {
+	suffix string
+	labels [][2]string
+	value  float64
+}
+
+func (f *family) add(labels [][2]string, value float64) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// write writes f in the text format, skipping families without samples, and returns the
+// first write error
+func (f *family) write(w io.Writer) error {
+	if len(f.samples) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind); err != nil {
+		return err
+	}
+	for _, s := range f.samples {
+		if _, err := io.WriteString(w, f.line(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line formats a sample of f
+func (f *family) line(s sample) string {
+	var b strings.Builder
+	b.WriteString(f.name + s.suffix)
+	if len(s.labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range s.labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, label[0], labelEscaper.Replace(label[1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(&b, " %s\n", formatValue(s.value))
+	return b.String()
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue formats a sample value
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// poolFamilies are the metrics of the pgxpool and database/sql statistics
+type poolFamilies struct {
+	max, total, acquired, idle, constructing                     family
+	acquires, acquireSeconds, emptyAcquires, emptyAcquireSeconds family
+	canceledAcquires, newConns, lifetimeDestroys, idleDestroys   family
+	sqlMax, sqlOpen, sqlInUse, sqlIdle                           family
+	sqlWaits, sqlWaitSeconds, sqlIdleClosed, sqlIdleTimeClosed   family
+	sqlLifetimeClosed                                            family
+}
+
+func newPoolFamilies() *poolFamilies {
+	return &poolFamilies{
+		max:                 family{name: "mapbot_db_pool_max_conns", kind: "gauge", help: "Maximum size of the pgx pool."},
+		total:               family{name: "mapbot_db_pool_total_conns", kind: "gauge", help: "Connections of the pgx pool, acquired, idle or being opened."},
+		acquired:            family{name: "mapbot_db_pool_acquired_conns", kind: "gauge", help: "Connections of the pgx pool in use."},
+		idle:                family{name: "mapbot_db_pool_idle_conns", kind: "gauge", help: "Idle connections of the pgx pool."},
+		constructing:        family{name: "mapbot_db_pool_constructing_conns", kind: "gauge", help: "Connections of the pgx pool being opened."},
+		acquires:            family{name: "mapbot_db_pool_acquires_total", kind: "counter", help: "Successful acquires from the pgx pool."},
+		acquireSeconds:      family{name: "mapbot_db_pool_acquire_duration_seconds_total", kind: "counter", help: "Time spent in successful acquires from the pgx pool."},
+		emptyAcquires:       family{name: "mapbot_db_pool_empty_acquires_total", kind: "counter", help: "Acquires from the pgx pool that waited for a connection."},
+		emptyAcquireSeconds: family{name: "mapbot_db_pool_empty_acquire_wait_seconds_total", kind: "counter", help: "Time spent waiting for a connection of the pgx pool."},
+		canceledAcquires:    family{name: "mapbot_db_pool_canceled_acquires_total", kind: "counter", help: "Acquires from the pgx pool canceled by their context."},
+		newConns:            family{name: "mapbot_db_pool_new_conns_total", kind: "counter", help: "Connections opened by the pgx pool."},
+		lifetimeDestroys:    family{name: "mapbot_db_pool_max_lifetime_destroys_total", kind: "counter", help: "Connections of the pgx pool closed at their maximum lifetime."},
+		idleDestroys:        family{name: "mapbot_db_pool_max_idle_destroys_total", kind: "counter", help: "Connections of the pgx pool closed at their maximum idle time."},
+		sqlMax:              family{name: "mapbot_db_sql_max_open_conns", kind: "gauge", help: "Maximum open connections of database/sql, 0 for unlimited."},
+		sqlOpen:             family{name: "mapbot_db_sql_open_conns", kind: "gauge", help: "Open connections of database/sql."},
+		sqlInUse:            family{name: "mapbot_db_sql_in_use_conns", kind: "gauge", help: "Connections of database/sql in use."},
+		sqlIdle:             family{name: "mapbot_db_sql_idle_conns", kind: "gauge", help: "Idle connections of database/sql."},
+		sqlWaits:            family{name: "mapbot_db_sql_waits_total", kind: "counter", help: "Connections of database/sql waited for."},
+		sqlWaitSeconds:      family{name: "mapbot_db_sql_wait_duration_seconds_total", kind: "counter", help: "Time spent waiting for connections of database/sql."},
+		sqlIdleClosed:       family{name: "mapbot_db_sql_max_idle_closed_total", kind: "counter", help: "Connections of database/sql closed by the idle limit."},
+		sqlIdleTimeClosed:   family{name: "mapbot_db_sql_max_idle_time_closed_total", kind: "counter", help: "Connections of database/sql closed at their maximum idle time."},
+		sqlLifetimeClosed:   family{name: "mapbot_db_sql_max_lifetime_closed_total", kind: "counter", help: "Connections of database/sql closed at their maximum lifetime."},
+	}
+}
+
+// add adds the statistics of a pgxpool and of the *sql.DB on top of it
+func (f *poolFamilies) add(labels [][2]string, pool *pgxpool.Pool, db *sql.DB) {
+	stat := pool.Stat()
+	f.max.add(labels, float64(stat.MaxConns()))
+	f.total.add(labels, float64(stat.TotalConns()))
+	f.acquired.add(labels, float64(stat.AcquiredConns()))
+	f.idle.add(labels, float64(stat.IdleConns()))
+	f.constructing.add(labels, float64(stat.ConstructingConns()))
+	f.acquires.add(labels, float64(stat.AcquireCount()))
+	f.acquireSeconds.add(labels, stat.AcquireDuration().Seconds())
+	f.emptyAcquires.add(labels, float64(stat.EmptyAcquireCount()))
+	f.emptyAcquireSeconds.add(labels, stat.EmptyAcquireWaitTime().Seconds())
+	f.canceledAcquires.add(labels, float64(stat.CanceledAcquireCount()))
+	f.newConns.add(labels, float64(stat.NewConnsCount()))
+	f.lifetimeDestroys.add(labels, float64(stat.MaxLifetimeDestroyCount()))
+	f.idleDestroys.add(labels, float64(stat.MaxIdleDestroyCount()))
+
+	stats := db.Stats()
+	f.sqlMax.add(labels, float64(stats.MaxOpenConnections))
+	f.sqlOpen.add(labels, float64(stats.OpenConnections))
+	f.sqlInUse.add(labels, float64(stats.InUse))
+	f.sqlIdle.add(labels, float64(stats.Idle))
+	f.sqlWaits.add(labels, float64(stats.WaitCount))
+	f.sqlWaitSeconds.add(labels, stats.WaitDuration.Seconds())
+	f.sqlIdleClosed.add(labels, float64(stats.MaxIdleClosed))
+	f.sqlIdleTimeClosed.add(labels, float64(stats.MaxIdleTimeClosed))
+	f.sqlLifetimeClosed.add(labels, float64(stats.MaxLifetimeClosed))
+}
+
+func (f *poolFamilies) all() []*family {
+	return []*family{&f.max, &f.total, &f.acquired, &f.idle, &f.constructing,
+		&f.acquires, &f.acquireSeconds, &f.emptyAcquires, &f.emptyAcquireSeconds,
+		&f.canceledAcquires, &f.newConns, &f.lifetimeDestroys, &f.idleDestroys,
+		&f.sqlMax, &f.sqlOpen, &f.sqlInUse, &f.sqlIdle,
+		&f.sqlWaits, &f.sqlWaitSeconds, &f.sqlIdleClosed, &f.sqlIdleTimeClosed, &f.sqlLifetimeClosed}
+}
+
+// collect returns a snapshot of all metrics
+func (m *Metrics) collect() []*family {
+	m.mu.Lock()
+	managers := slices.Clone(m.managers)
+	keys := make([]queryKey, 0, len(m.queries))
+	for key := range m.queries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b queryKey) int {
+		return strings.Compare(a.database+"\x00"+a.operation, b.database+"\x00"+b.operation)
+	})
+	queries := make([]queryStats, len(keys))
+	for i, key := range keys {
+		queries[i] = *m.queries[key]
+		queries[i].buckets = slices.Clone(queries[i].buckets)
+	}
+	m.mu.Unlock()
+
+	pools := newPoolFamilies()
+	for _, registered := range managers {
+		dm := registered.dm
+		database := [2]string{"database", registered.database}
+		manager := [2]string{"manager", registered.id}
+		pools.add([][2]string{database, manager, {"pool", "primary"}}, dm.GetPool(), dm.db)
+		for i, r := range dm.replicaList() {
+			pools.add([][2]string{database, manager, {"pool", fmt.Sprintf("replica-%d", i+1)}}, r.pool, r.db)
+		}
+	}
+
+	count := family{name: "mapbot_db_queries_total", kind: "counter", help: "Queries run, by operation and status."}
+	latency := family{name: "mapbot_db_query_duration_seconds", kind: "histogram", help: "Latency of the queries, by operation."}
+	for i, key := range keys {
+		stats := queries[i]
+		labels := [][2]string{{"database", key.database}, {"operation", key.operation}}
+		count.add(append(slices.Clone(labels), [2]string{"status", "ok"}), float64(stats.ok))
+		count.add(append(slices.Clone(labels), [2]string{"status", "error"}), float64(stats.errors))
+
+		var cumulated uint64
+		for j, bound := range m.buckets {
+			cumulated += stats.buckets[j]
+			latency.samples = append(latency.samples, sample{suffix: "_bucket",
+				labels: append(slices.Clone(labels), [2]string{"le", formatValue(bound)}), value: float64(cumulated)})
+		}
+		total := float64(stats.ok + stats.errors)
+		latency.samples = append(latency.samples,
+			sample{suffix: "_bucket", labels: append(slices.Clone(labels), [2]string{"le", "+Inf"}), value: total},
+			sample{suffix: "_sum", labels: labels, value: stats.sum},
+			sample{suffix: "_count", labels: labels, value: total})
+	}
+	return append(pools.all(), &count, &latency)
+}
+
+// metricsTracer times the queries of a manager for Metrics
+type metricsTracer struct {
+	metrics  *Metrics
+	database string
+}
+
+// metricsStartKey is the context key of the start of a traced query
+type metricsStartKey struct{}
+
+// queryStart is the start of a traced query
+type queryStart struct {
+	at        time.Time
+	operation string
+}
+
+func (t *metricsTracer) start(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, metricsStartKey{}, queryStart{at: time.Now(), operation: operation})
+}
+
+func (t *metricsTracer) end(ctx context.Context, err error) {
+	if start, ok := ctx.Value(metricsStartKey{}).(queryStart); ok {
+		t.metrics.observe(t.database, start.operation, time.Since(start.at), err)
+	}
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (t *metricsTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, sqlOperation(data.SQL))
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (t *metricsTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceBatchStart implements pgx.BatchTracer, a batch counting as one BATCH operation
+func (t *metricsTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, "BATCH")
+}
+
+// TraceBatchQuery implements pgx.BatchTracer
+func (t *metricsTracer) TraceBatchQuery(context.Context, *pgx.Conn, pgx.TraceBatchQueryData) {}
+
+// TraceBatchEnd implements pgx.BatchTracer
+func (t *metricsTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer
+func (t *metricsTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx, "COPY")
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer
+func (t *metricsTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.Err)
+}
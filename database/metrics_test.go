@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the metrics served by the handler of m
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	return recorder.Body.String()
+}
+
+// TestMetrics tests the pool and query metrics of both APIs
+func TestMetrics(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	server.failWith(func(query string) string {
+		if strings.HasPrefix(query, "DELETE") {
+			return "23503"
+		}
+		return ""
+	})
+	metrics := NewMetrics()
+	dm, err := NewManager(server.config(), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	ctx := context.Background()
+	if _, err := dm.GetPool().Exec(ctx, "UPDATE tiles SET version = 2"); err != nil {
+		t.Fatalf("pgxpool Exec() error = %v", err)
+	}
+	if _, err := dm.GetDB().ExecContext(ctx, "/* import */ INSERT INTO tiles DEFAULT VALUES"); err != nil {
+		t.Fatalf("database/sql Exec() error = %v", err)
+	}
+	if _, err := dm.GetPool().Exec(ctx, "DELETE FROM tiles"); err == nil {
+		t.Fatal("DELETE should fail")
+	}
+	// database/sql releases its connections to the pool in the background
+	for deadline := time.Now().Add(time.Second); dm.GetPool().Stat().AcquiredConns() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := dm.GetPool().Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	body := scrape(t, metrics)
+	conn.Release()
+
+	for _, line := range []string{
+		"# TYPE mapbot_db_pool_acquired_conns gauge",
+		`mapbot_db_pool_acquired_conns{database="mapbot",manager="1",pool="primary"} 1`,
+		`mapbot_db_pool_max_conns{database="mapbot",manager="1",pool="primary"} 25`,
+		`mapbot_db_sql_max_open_conns{database="mapbot",manager="1",pool="primary"} 0`,
+		"# TYPE mapbot_db_queries_total counter",
+		`mapbot_db_queries_total{database="mapbot",operation="UPDATE",status="ok"} 1`,
+		`mapbot_db_queries_total{database="mapbot",operation="INSERT",status="ok"} 1`,
+		`mapbot_db_queries_total{database="mapbot",operation="DELETE",status="error"} 1`,
+		"# TYPE mapbot_db_query_duration_seconds histogram",
+		`mapbot_db_query_duration_seconds_bucket{database="mapbot",operation="UPDATE",le="+Inf"} 1`,
+		`mapbot_db_query_duration_seconds_count{database="mapbot",operation="INSERT"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+
+	second, err := NewManager(server.config(), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("NewManager() of a second manager of the database error = %v", err)
+	}
+	body = scrape(t, metrics)
+	for _, line := range []string{
+		`mapbot_db_pool_max_conns{database="mapbot",manager="1",pool="primary"} 25`,
+		`mapbot_db_pool_max_conns{database="mapbot",manager="2",pool="primary"} 25`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if body := scrape(t, metrics); strings.Contains(body, `manager="2"`) || !strings.Contains(body, `manager="1"`) {
+		t.Errorf("Close() should only remove the pools of its manager:\n%s", body)
+	}
+
+	if err := dm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if body := scrape(t, metrics); strings.Contains(body, "mapbot_db_pool_") {
+		t.Errorf("metrics still contain the pools of a closed manager:\n%s", body)
+	}
+	failing := OptionFunc(func(context.Context, *Manager) error { return errors.New("failing option") })
+	if _, err := NewManager(server.config(), WithMetrics(metrics), failing); err == nil {
+		t.Fatal("NewManager() with a failing option should fail")
+	}
+	again, err := NewManager(server.config(), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("NewManager() after Close error = %v", err)
+	}
+	defer func() { _ = again.Close() }()
+	if body := scrape(t, metrics); !strings.Contains(body, `mapbot_db_queries_total{database="mapbot",operation="UPDATE",status="ok"} 1`) {
+		t.Errorf("metrics lost the queries of the closed manager:\n%s", body)
+	}
+}
+
+// failingWriter fails every write after the first n bytes
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("connection reset")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestMetricsWriteError tests that WriteTo returns the error of the writer
+func TestMetricsWriteError(t *testing.T) {
+	metrics := NewMetrics()
+	for i := range 200 {
+		metrics.observe("mapbot", fmt.Sprintf("OP%d", i), time.Millisecond, nil)
+	}
+	n, err := metrics.WriteTo(&failingWriter{n: 100})
+	if err == nil || err.Error() != "connection reset" {
+		t.Errorf("WriteTo() error = %v, want connection reset", err)
+	}
+	if n != 100 {
+		t.Errorf("WriteTo() = %d bytes, want 100", n)
+	}
+}
+
+// TestMetricsHistogram tests the cumulated buckets of the latency histogram
+func TestMetricsHistogram(t *testing.T) {
+	metrics := NewMetrics(0.1, 0.01, 1)
+	for _, duration := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, 2 * time.Second} {
+		metrics.observe(`tiles "eu"`, "SELECT", duration, nil)
+	}
+
+	body := scrape(t, metrics)
+	labels := `database="tiles \"eu\"",operation="SELECT"`
+	for _, line := range []string{
+		`mapbot_db_query_duration_seconds_bucket{` + labels + `,le="0.01"} 2`,
+		`mapbot_db_query_duration_seconds_bucket{` + labels + `,le="0.1"} 3`,
+		`mapbot_db_query_duration_seconds_bucket{` + labels + `,le="1"} 3`,
+		`mapbot_db_query_duration_seconds_bucket{` + labels + `,le="+Inf"} 4`,
+		`mapbot_db_query_duration_seconds_sum{` + labels + `} 2.065`,
+		`mapbot_db_query_duration_seconds_count{` + labels + `} 4`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "mapbot_db_pool_") {
+		t.Errorf("metrics without manager contain pool metrics:\n%s", body)
+	}
+}
@@ -1,13 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"path/filepath"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 
 	// Import the file source for migrations
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -17,6 +21,13 @@ import (
 // schemaName: schema where the migrations table will be created (e.g., "public", "etl_migrations")
 // tableName: name of the migrations tracking table (e.g., "schema_migrations")
 func RunMigrations(db *sql.DB, migrationsPath string, schemaName, tableName string) error {
+	return RunMigrationsContext(context.Background(), db, migrationsPath, schemaName, tableName)
+}
+
+// RunMigrationsContext is RunMigrations stopping when ctx is done. The migration being
+// applied completes first, so that the schema version is left clean, and the remaining
+// ones are skipped; the error then wraps ctx.Err().
+func RunMigrationsContext(ctx context.Context, db *sql.DB, migrationsPath string, schemaName, tableName string) error {
 	if schemaName == "" {
 		schemaName = "public"
 	}
@@ -25,16 +36,21 @@ func RunMigrations(db *sql.DB, migrationsPath string, schemaName, tableName stri
 	}
 
 	// Create schema for migrations if it doesn't exist
-	_, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schemaName))
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schemaName))
 	if err != nil {
 		return fmt.Errorf("unable to create migration schema %s: %w", schemaName, err)
 	}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get a migration connection: %w", err)
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{
 		MigrationsTable: tableName,
 		SchemaName:      schemaName,
 	})
 	if err != nil {
+		_ = conn.Close()
 		return fmt.Errorf("unable to create migration driver: %w", err)
 	}
 
@@ -58,10 +74,15 @@ func RunMigrations(db *sql.DB, migrationsPath string, schemaName, tableName stri
 		driver,
 	)
 	if err != nil {
+		_ = driver.Close()
 		return fmt.Errorf("unable to create migration instance: %w", err)
 	}
+	// Closes the connection only, db is left open
+	defer func() { _, _ = m.Close() }()
 
-	// Apply all migrations
+	// Apply all migrations, stopping between two of them when ctx is done
+	stop := context.AfterFunc(ctx, func() { m.GracefulStop <- true })
+	defer stop()
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		return fmt.Errorf("migration failed: %w", err)
 	}
@@ -72,6 +93,17 @@ func RunMigrations(db *sql.DB, migrationsPath string, schemaName, tableName stri
 		return fmt.Errorf("unable to get migration version: %w", err)
 	}
 
+	// ctx may be done once Up has applied the last migration, the stop signal is then ignored
+	if ctx.Err() != nil {
+		pending, perr := pendingMigrations(sourceURL, version, err == nil)
+		if perr != nil {
+			return fmt.Errorf("unable to check the remaining migrations: %w", perr)
+		}
+		if pending {
+			return fmt.Errorf("migrations interrupted: %w", ctx.Err())
+		}
+	}
+
 	if err == migrate.ErrNilVersion {
 		slog.Debug("No migrations applied yet")
 	} else {
@@ -84,3 +116,22 @@ func RunMigrations(db *sql.DB, migrationsPath string, schemaName, tableName stri
 
 	return nil
 }
+
+// pendingMigrations reports whether the source at sourceURL has migrations after version,
+// or any migration when none is applied
+func pendingMigrations(sourceURL string, version uint, applied bool) (bool, error) {
+	src, err := source.Open(sourceURL)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = src.Close() }()
+	if applied {
+		_, err = src.Next(version)
+	} else {
+		_, err = src.First()
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPendingMigrations tests the detection of migrations left by an interrupted Up
+func TestPendingMigrations(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1_tiles.up.sql", "2_styles.up.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	sourceURL := "file://" + filepath.ToSlash(dir)
+
+	tests := []struct {
+		name    string
+		version uint
+		applied bool
+		want    bool
+	}{
+		{"none applied", 0, false, true},
+		{"first applied", 1, true, true},
+		{"all applied", 2, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pendingMigrations(sourceURL, tt.version, tt.applied)
+			if err != nil {
+				t.Fatalf("pendingMigrations() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("pendingMigrations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// WithDualPools is an option to keep the legacy behaviour where GetDB has its own
+// database/sql connection pool next to the pgxpool of GetPool. Each pool then opens up to
+// MaxOpenConns connections, and Stats only reports the database/sql pool.
+//
+// Deprecated: by default GetDB is backed by the pgxpool, which shares the limits,
+// statistics and credentials of both APIs.
+func WithDualPools() ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		dm.dualPools = true
+		return nil
+	})
+}
+
+// poolConnector is the database/sql connector of a Manager that borrows its connections
+// from the current pgxpool, so that GetDB keeps working after ApplyConfig replaces it
+type poolConnector struct {
+	dm *Manager
+}
+
+// Connect acquires a connection of the pgxpool, which is released when database/sql closes it
+func (c poolConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	pool := c.dm.GetPool()
+	if pool == nil {
+		return nil, fmt.Errorf("connection pool is not ready")
+	}
+	return stdlib.GetPoolConnector(pool, stdlib.OptionResetSession(c.dm.resetSession)).Connect(ctx)
+}
+
+// Driver returns the pgx database/sql driver
+func (c poolConnector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+// openSharedDB opens a *sql.DB on top of the pgxpool of dm. It keeps no idle connection
+// and has no limit of its own: connections go back to the pgxpool after each use, and
+// the pgxpool bounds them
+func openSharedDB(dm *Manager) *sql.DB {
+	db := sql.OpenDB(poolConnector{dm: dm})
+	db.SetMaxIdleConns(0)
+	return db
+}
+
+// poolStats reports the statistics of pool in the form of database/sql
+func poolStats(pool *pgxpool.Pool) sql.DBStats {
+	stat := pool.Stat()
+	return sql.DBStats{
+		MaxOpenConnections: int(stat.MaxConns()),
+		OpenConnections:    int(stat.TotalConns()),
+		InUse:              int(stat.AcquiredConns()),
+		Idle:               int(stat.IdleConns()),
+		WaitCount:          stat.EmptyAcquireCount(),
+		WaitDuration:       stat.EmptyAcquireWaitTime(),
+		MaxIdleTimeClosed:  stat.MaxIdleDestroyCount(),
+		MaxLifetimeClosed:  stat.MaxLifetimeDestroyCount(),
+	}
+}
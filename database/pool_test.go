@@ -0,0 +1,87 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/database"
+	"github.com/pixime-net/mapbot-shared/testutils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// TestSharedPool tests that GetDB and GetPool share the connections of a single pool,
+// unless WithDualPools is given
+func TestSharedPool(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	pgContainer, err := testutils.SetupPostGISContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start PostGIS container: %v", err)
+	}
+	t.Cleanup(func() { _ = pgContainer.Terminate(context.Background()) })
+	cfg, err := pgContainer.Config(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get container configuration: %v", err)
+	}
+	cfg.MaxOpenConns = 2
+	cfg.MaxIdleConns = 1
+
+	tests := []struct {
+		name      string
+		opts      []database.ManagerOption
+		wantQuery bool // whether GetDB still connects while the pgxpool is exhausted
+	}{
+		{name: "shared", wantQuery: false},
+		{name: "dual pools", opts: []database.ManagerOption{database.WithDualPools()}, wantQuery: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, err := database.NewManager(cfg, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			defer func() { _ = dm.Close() }()
+
+			var held []*pgxpool.Conn
+			for i := 0; i < cfg.MaxOpenConns; i++ {
+				conn, err := dm.GetPool().Acquire(ctx)
+				if err != nil {
+					t.Fatalf("Acquire() error = %v", err)
+				}
+				held = append(held, conn)
+			}
+
+			queryCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			err = dm.GetDB().PingContext(queryCtx)
+			cancel()
+			if tt.wantQuery && err != nil {
+				t.Errorf("database/sql ping error = %v", err)
+			}
+			if !tt.wantQuery && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("database/sql ping error = %v, want to wait for the exhausted pool", err)
+			}
+
+			stats := dm.Stats()
+			if !tt.wantQuery && (stats.InUse != cfg.MaxOpenConns || stats.MaxOpenConnections != cfg.MaxOpenConns) {
+				t.Errorf("Stats() = %+v, want %d connections in use", stats, cfg.MaxOpenConns)
+			}
+			if !tt.wantQuery {
+				if err := dm.Health(ctx); err == nil {
+					t.Error("Health() should report the exhausted pool")
+				}
+			}
+
+			for _, conn := range held {
+				conn.Release()
+			}
+			if err := dm.GetDB().PingContext(ctx); err != nil {
+				t.Errorf("database/sql ping after release error = %v", err)
+			}
+		})
+	}
+}
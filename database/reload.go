@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pixime-net/mapbot-shared/config"
+	"github.com/pixime-net/mapbot-shared/logger"
+)
+
+// ApplyConfig switches a running Manager to cfg without dropping in-flight queries.
+//
+// The pgxpool cannot be resized, so when pool sizes or connection lifetimes change
+// GetPool returns a new pool and the previous one is closed once its acquired
+// connections are released; GetDB follows the new pool. With WithDualPools, the
+// database/sql pool is resized in place. When any connection setting
+// changes, such as the password after a rotation, new connections use cfg and existing
+// ones are closed as soon as they are idle; queries running on them complete first.
+//
+// An invalid cfg is reported as a *config.ValidationError and leaves the Manager unchanged.
+func (dm *Manager) ApplyConfig(cfg *config.PostgresDatabase) error {
+	if cfg == nil {
+		return fmt.Errorf("database config cannot be nil")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	poolConfig, err := newPoolConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	previous := dm.config
+	reconnect := connectionChanged(previous, cfg)
+	resize := poolChanged(previous, cfg)
+	dm.config = cfg
+	if reconnect {
+		dm.connConfig = poolConfig.ConnConfig.Copy()
+		dm.connConfig.Tracer = dm.tracer
+		dm.generation++
+	}
+	dm.mu.Unlock()
+
+	if dm.dualPools {
+		configureDB(dm.db, cfg)
+		if reconnect {
+			// Close the idle connections now, those in use are discarded when released
+			dm.db.SetMaxIdleConns(0)
+			dm.db.SetMaxIdleConns(cfg.MaxIdleConns)
+		}
+	}
+
+	if !resize {
+		if reconnect {
+			dm.GetPool().Reset()
+		}
+		return nil
+	}
+
+	pool, err := dm.newPool(context.Background(), poolConfig)
+	if err != nil {
+		return fmt.Errorf("error creating connection pool: %w", err)
+	}
+	dm.mu.Lock()
+	old := dm.pool
+	dm.pool = pool
+	dm.mu.Unlock()
+	// Close blocks until the acquired connections are released
+	go old.Close()
+	return nil
+}
+
+// connectionChanged reports whether a and b differ in anything but pool settings
+func connectionChanged(a, b *config.PostgresDatabase) bool {
+	a2, b2 := *a, *b
+	for _, cfg := range []*config.PostgresDatabase{&a2, &b2} {
+		cfg.MaxOpenConns, cfg.MaxIdleConns = 0, 0
+		cfg.MaxConnLifetime, cfg.MaxConnIdleTime = 0, 0
+		cfg.ConnMaxLifetime, cfg.ConnMaxIdleTime = 0, 0
+	}
+	return !reflect.DeepEqual(a2, b2)
+}
+
+// poolChanged reports whether a and b differ in settings of the pgxpool
+func poolChanged(a, b *config.PostgresDatabase) bool {
+	return a.MaxOpenConns != b.MaxOpenConns || a.MaxIdleConns != b.MaxIdleConns ||
+		a.EffectiveMaxConnLifetime() != b.EffectiveMaxConnLifetime() ||
+		a.EffectiveMaxConnIdleTime() != b.EffectiveMaxConnIdleTime()
+}
+
+// WatchConfig applies the database section of every configuration published by w to dm
+// with ApplyConfig, for example:
+//
+//	stop := database.WatchConfig(dm, watcher, func(c *ServiceConfig) *config.PostgresDatabase {
+//		return c.Database
+//	})
+//
+// Failures are logged and leave dm on its current configuration. The returned function
+// stops watching.
+func WatchConfig[T any](dm *Manager, w *config.Watcher[T], section func(*T) *config.PostgresDatabase) (stop func()) {
+	return w.Subscribe(func(event config.ChangeEvent[T]) {
+		next := section(event.New)
+		if reflect.DeepEqual(section(event.Old), next) {
+			return
+		}
+		if err := dm.ApplyConfig(next); err != nil {
+			logger.GetLogger().Error("Failed to apply database configuration", "database", next, "error", err)
+			return
+		}
+		logger.GetLogger().Info("Database configuration applied", "database", next)
+	})
+}
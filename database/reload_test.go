@@ -0,0 +1,157 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/config"
+	"github.com/pixime-net/mapbot-shared/database"
+	"github.com/pixime-net/mapbot-shared/testutils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// reloadConfig is a service configuration with a database section
+type reloadConfig struct {
+	Database *config.PostgresDatabase `env:"DB_" config:"database"`
+}
+
+// TestWatchConfigRotatesCredentials tests that a password rotation and a pool resize are
+// applied live while queries are running
+func TestWatchConfigRotatesCredentials(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	pgContainer, err := testutils.SetupPostGISContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start PostGIS container: %v", err)
+	}
+	t.Cleanup(func() { _ = pgContainer.Terminate(context.Background()) })
+	containerCfg, err := pgContainer.Config(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get container configuration: %v", err)
+	}
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "db_password")
+	configFile := filepath.Join(dir, "service.json")
+	writeConfig := func(password string, maxOpenConns int) {
+		t.Helper()
+		if err := os.WriteFile(secretFile, []byte(password), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		content := fmt.Sprintf(`{"database": {"host": %q, "port": %d, "name": %q, "user": %q,
+			"password": "file://%s", "max_open_conns": %d, "max_idle_conns": 2}}`,
+			containerCfg.Host, containerCfg.Port, containerCfg.Database, containerCfg.User, secretFile, maxOpenConns)
+		if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(containerCfg.Password, 5)
+
+	watcher, err := config.NewWatcher[reloadConfig](time.Hour, config.WithFile(configFile), config.WithDotEnv(),
+		config.WithEnvPrefix("RELOAD_TEST_"))
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	dm, err := database.NewManager(watcher.Current().Database)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+	stop := database.WatchConfig(dm, watcher, func(c *reloadConfig) *config.PostgresDatabase { return c.Database })
+	defer stop()
+
+	// Queries running during the rotation, on both pools
+	inFlight := make(chan error, 2)
+	started := make(chan struct{}, 2)
+	go func() {
+		conn, err := dm.GetDB().Conn(ctx)
+		if err != nil {
+			inFlight <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		started <- struct{}{}
+		_, err = conn.ExecContext(ctx, "SELECT pg_sleep(2)")
+		inFlight <- err
+	}()
+	go func() {
+		conn, err := dm.GetPool().Acquire(ctx)
+		if err != nil {
+			inFlight <- err
+			return
+		}
+		defer conn.Release()
+		started <- struct{}{}
+		_, err = conn.Exec(ctx, "SELECT pg_sleep(2)")
+		inFlight <- err
+	}()
+	<-started
+	<-started
+
+	admin, err := pgx.Connect(ctx, pgContainer.ConnectionString)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = admin.Close(ctx) }()
+	var rotatedAt time.Time
+	if err := admin.QueryRow(ctx, "SELECT clock_timestamp()").Scan(&rotatedAt); err != nil {
+		t.Fatalf("Failed to read the server time: %v", err)
+	}
+	if _, err := admin.Exec(ctx, "ALTER ROLE "+containerCfg.User+" PASSWORD 'rotated-password'"); err != nil {
+		t.Fatalf("Failed to rotate password: %v", err)
+	}
+
+	writeConfig("rotated-password", 8)
+	if err := watcher.Reload(ctx); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-inFlight; err != nil {
+			t.Errorf("in-flight query failed during the rotation: %v", err)
+		}
+	}
+
+	if got := dm.GetConfig().Password; got != "rotated-password" {
+		t.Errorf("GetConfig().Password = %q, want the rotated password", got)
+	}
+	if got := dm.GetPool().Config().MaxConns; got != 8 {
+		t.Errorf("pool MaxConns = %d, want 8", got)
+	}
+	if got := dm.Stats().MaxOpenConnections; got != 8 {
+		t.Errorf("database/sql MaxOpenConnections = %d, want 8", got)
+	}
+
+	// Every connection opened before the rotation is gone, so these need the new password
+	for i := 0; i < 3; i++ {
+		if err := dm.GetDB().PingContext(ctx); err != nil {
+			t.Errorf("database/sql ping after rotation error = %v", err)
+		}
+		if err := dm.GetPool().Ping(ctx); err != nil {
+			t.Errorf("pgxpool ping after rotation error = %v", err)
+		}
+	}
+	// The previous pgxpool is closed in the background, give it a moment
+	var oldSessions int
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		err = admin.QueryRow(ctx, `SELECT count(*) FROM pg_stat_activity
+			WHERE usename = $1 AND backend_start < $2 AND pid <> pg_backend_pid()`,
+			containerCfg.User, rotatedAt).Scan(&oldSessions)
+		if err != nil {
+			t.Fatalf("pg_stat_activity query error = %v", err)
+		}
+		if oldSessions == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if oldSessions > 0 {
+		t.Errorf("%d sessions opened before the rotation are still open", oldSessions)
+	}
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/config"
+	"github.com/pixime-net/mapbot-shared/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// DefaultReplicaCheckPeriod is the period of the replica health checks when
+// ReplicaOptions.CheckPeriod is zero
+const DefaultReplicaCheckPeriod = 10 * time.Second
+
+// ReplicaOptions configures the health tracking of read replicas
+type ReplicaOptions struct {
+	// CheckPeriod is the period of the health checks (DefaultReplicaCheckPeriod when zero)
+	CheckPeriod time.Duration
+	// MaxLag is the replication lag above which a replica stops serving reads, zero
+	// disables the check. A standby is up to date when it streams from the primary and
+	// replayed all it received, which only the roles with pg_read_all_stats (such as
+	// pg_monitor) can see; the lag is the age of its last replayed transaction otherwise.
+	MaxLag time.Duration
+}
+
+// ReplicaStatus is the state of a read replica at its last health check
+type ReplicaStatus struct {
+	Database  string        // public connection string of the replica
+	Healthy   bool          // whether Reader may return the replica
+	Lag       time.Duration // replication lag, measured when ReplicaOptions.MaxLag is set
+	LastError error         // failure of the last check, nil when healthy
+	CheckedAt time.Time
+}
+
+// replica is a read replica and its health
+type replica struct {
+	config *config.PostgresDatabase
+	pool   *pgxpool.Pool
+	db     *sql.DB
+
+	mu     sync.RWMutex
+	status ReplicaStatus
+}
+
+// replicaLagQuery returns what replicationLag needs to tell the replication lag of a
+// standby: whether it is in recovery, has replayed all the WAL it received, is streaming
+// from the primary, and the age in seconds of its last replayed transaction.
+// pg_stat_wal_receiver only shows the status to roles with pg_read_all_stats.
+const replicaLagQuery = `SELECT pg_is_in_recovery(),
+	COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), false),
+	EXISTS (SELECT FROM pg_stat_wal_receiver WHERE status = 'streaming'),
+	EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8`
+
+// replicationLag returns the lag of a standby from the results of replicaLagQuery. A
+// streaming standby that replayed everything it received is up to date, however old its
+// last replayed transaction, which happens when the primary is idle. Otherwise, such as
+// when the standby lost its connection to the primary, the lag is the age of its last
+// replayed transaction, and unknown if it replayed none.
+func replicationLag(inRecovery, replayedAll, streaming bool, replayAge *float64) (time.Duration, error) {
+	switch {
+	case !inRecovery || (replayedAll && streaming):
+		return 0, nil
+	case replayAge == nil:
+		return 0, errors.New("replication lag unknown: the standby is not streaming and replayed no transaction")
+	}
+	return time.Duration(*replayAge * float64(time.Second)), nil
+}
+
+// WithReplicas is an option to route reads to read replicas: Reader and ReaderDB return
+// one of the healthy replicas in turn, and the primary when none is healthy. Replicas
+// are checked when the option is applied and then every opts.CheckPeriod; a replica that
+// is down, or lags by more than opts.MaxLag, is skipped until a check succeeds again.
+// Replicas do not need to be reachable at startup. ApplyConfig only changes the primary.
+// A replica with a password of its own connects with it; the others get their
+// credentials from WithCredentialsProvider, if any. The option can only be applied once.
+func WithReplicas(opts ReplicaOptions, replicas ...*config.PostgresDatabase) ManagerOption {
+	return OptionFunc(func(ctx context.Context, dm *Manager) error {
+		if dm.stopReplicas != nil {
+			return errors.New("replicas are already configured")
+		}
+		if opts.CheckPeriod <= 0 {
+			opts.CheckPeriod = DefaultReplicaCheckPeriod
+		}
+		for i, cfg := range replicas {
+			if cfg == nil {
+				return fmt.Errorf("replica %d config cannot be nil", i)
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid replica %d: %w", i, err)
+			}
+		}
+		dm.replicaOpts = opts
+		return dm.startReplicas(ctx, replicas)
+	})
+}
+
+// startReplicas opens the pools of the replicas, checks them and starts the periodic checks
+func (dm *Manager) startReplicas(ctx context.Context, replicas []*config.PostgresDatabase) error {
+	for _, cfg := range replicas {
+		poolConfig, err := newPoolConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("replica %s: %w", cfg.PublicConnectionString(), err)
+		}
+		poolConfig.ConnConfig.Tracer = dm.tracer
+		if cfg.Password == "" {
+			poolConfig.BeforeConnect = dm.applyCredentials
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			return fmt.Errorf("error creating replica pool %s: %w", cfg.PublicConnectionString(), err)
+		}
+		r := &replica{config: cfg, pool: pool, db: stdlib.OpenDBFromPool(pool)}
+		r.status.Database = cfg.PublicConnectionString()
+		dm.mu.Lock()
+		dm.replicas = append(dm.replicas, r)
+		dm.mu.Unlock()
+	}
+	dm.checkReplicas(ctx)
+
+	checkCtx, cancel := context.WithCancel(context.Background())
+	dm.stopReplicas = cancel
+	dm.replicasDone = make(chan struct{})
+	go func() {
+		defer close(dm.replicasDone)
+		ticker := time.NewTicker(dm.replicaOpts.CheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-checkCtx.Done():
+				return
+			case <-ticker.C:
+				dm.checkReplicas(checkCtx)
+			}
+		}
+	}()
+	return nil
+}
+
+// checkReplicas checks all replicas concurrently
+func (dm *Manager) checkReplicas(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range dm.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dm.checkReplica(ctx, r)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkReplica pings r, measures its lag if needed, and logs the changes of its health
+func (dm *Manager) checkReplica(ctx context.Context, r *replica) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.EffectiveConnectTimeout())
+	defer cancel()
+
+	status := ReplicaStatus{Database: r.config.PublicConnectionString(), CheckedAt: time.Now()}
+	status.LastError = r.pool.Ping(ctx)
+	if status.LastError == nil && dm.replicaOpts.MaxLag > 0 {
+		var inRecovery, replayedAll, streaming bool
+		var replayAge *float64
+		err := r.pool.QueryRow(ctx, replicaLagQuery, pgx.QueryExecModeSimpleProtocol).
+			Scan(&inRecovery, &replayedAll, &streaming, &replayAge)
+		if err == nil {
+			status.Lag, err = replicationLag(inRecovery, replayedAll, streaming, replayAge)
+		}
+		switch {
+		case err != nil:
+			status.LastError = fmt.Errorf("failed to measure replication lag: %w", err)
+		case status.Lag > dm.replicaOpts.MaxLag:
+			status.LastError = fmt.Errorf("replication lag %s exceeds %s", status.Lag, dm.replicaOpts.MaxLag)
+		}
+	}
+	status.Healthy = status.LastError == nil
+
+	r.mu.Lock()
+	wasHealthy, checked := r.status.Healthy, !r.status.CheckedAt.IsZero()
+	r.status = status
+	r.mu.Unlock()
+
+	switch {
+	case !status.Healthy && (wasHealthy || !checked):
+		logger.GetLogger().Warn("Database replica unavailable, reading from other servers",
+			"replica", status.Database, "error", status.LastError)
+	case status.Healthy && !wasHealthy && checked:
+		logger.GetLogger().Info("Database replica available again", "replica", status.Database, "lag", status.Lag)
+	}
+}
+
+// healthyReplica returns the next healthy replica in turn, or nil if none is
+func (dm *Manager) healthyReplica() *replica {
+	n := len(dm.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := dm.nextReplica.Add(1)
+	for i := range n {
+		r := dm.replicas[(start+uint64(i))%uint64(n)] // #nosec G115 -- i is not negative
+		r.mu.RLock()
+		healthy := r.status.Healthy
+		r.mu.RUnlock()
+		if healthy {
+			return r
+		}
+	}
+	return nil
+}
+
+// Reader returns the pool of a healthy read replica, in turn, or the primary pool when
+// no replica is healthy or none is configured. Use it for queries that tolerate the
+// replication lag.
+func (dm *Manager) Reader() *pgxpool.Pool {
+	if r := dm.healthyReplica(); r != nil {
+		return r.pool
+	}
+	return dm.Writer()
+}
+
+// ReaderDB is Reader for database/sql callers
+func (dm *Manager) ReaderDB() *sql.DB {
+	if r := dm.healthyReplica(); r != nil {
+		return r.db
+	}
+	return dm.db
+}
+
+// Writer returns the pool of the primary, like GetPool
+func (dm *Manager) Writer() *pgxpool.Pool {
+	return dm.GetPool()
+}
+
+// Replicas returns the status of the read replicas at their last health check
+func (dm *Manager) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(dm.replicas))
+	for _, r := range dm.replicas {
+		r.mu.RLock()
+		statuses = append(statuses, r.status)
+		r.mu.RUnlock()
+	}
+	return statuses
+}
+
+// replicaList returns the replicas while they may still be added by NewManagerContext
+func (dm *Manager) replicaList() []*replica {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.replicas
+}
+
+// closeReplicas stops the health checks and closes the replica pools
+func (dm *Manager) closeReplicas() {
+	if dm.stopReplicas != nil {
+		dm.stopReplicas()
+		<-dm.replicasDone
+	}
+	for _, r := range dm.replicas {
+		_ = r.db.Close()
+		r.pool.Close()
+	}
+}
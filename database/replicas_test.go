@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestReplicas tests the routing of reads to the healthy replicas
+func TestReplicas(t *testing.T) {
+	logs := captureLogs(t)
+	primary := startFakeServer(t, func(int32) string { return "" })
+	healthy := startFakeServer(t, func(int32) string { return "" })
+	down := startFakeServer(t, func(int32) string { return "57P03" })
+
+	dm, err := NewManager(primary.config(),
+		WithReplicas(ReplicaOptions{CheckPeriod: time.Hour}, healthy.config(), down.config()))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	if dm.Writer() != dm.GetPool() {
+		t.Error("Writer() should be the primary pool")
+	}
+	readers := make(map[*pgxpool.Pool]bool)
+	for range 4 {
+		readers[dm.Reader()] = true
+	}
+	if len(readers) != 1 || readers[dm.Writer()] {
+		t.Errorf("Reader() returned %d pools, want the healthy replica only", len(readers))
+	}
+	if err := dm.ReaderDB().PingContext(context.Background()); err != nil || dm.ReaderDB() == dm.GetDB() {
+		t.Errorf("ReaderDB() should be the healthy replica, ping error = %v", err)
+	}
+
+	statuses := dm.Replicas()
+	if len(statuses) != 2 || !statuses[0].Healthy || statuses[1].Healthy || statuses[1].LastError == nil {
+		t.Errorf("Replicas() = %+v, want the second one unhealthy", statuses)
+	}
+	if !strings.Contains(logs.String(), "Database replica unavailable") {
+		t.Errorf("the unavailable replica was not logged:\n%s", logs)
+	}
+}
+
+// TestReplicaLag tests that a lagging replica is skipped until it catches up
+func TestReplicaLag(t *testing.T) {
+	logs := captureLogs(t)
+	primary := startFakeServer(t, func(int32) string { return "" })
+	standby := startFakeServer(t, func(int32) string { return "" })
+	var lag atomic.Value
+	lag.Store("30")
+	standby.rowsWith(func(query string) []string {
+		if query == replicaLagQuery {
+			return []string{"t", "f", "t", lag.Load().(string)}
+		}
+		return nil
+	})
+
+	dm, err := NewManager(primary.config(),
+		WithReplicas(ReplicaOptions{CheckPeriod: time.Hour, MaxLag: 5 * time.Second}, standby.config()))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	if dm.Reader() != dm.Writer() {
+		t.Error("Reader() should fall back to the primary while the replica lags")
+	}
+	if status := dm.Replicas()[0]; status.Healthy || status.Lag != 30*time.Second {
+		t.Errorf("Replicas() = %+v, want a 30s lag", status)
+	}
+
+	lag.Store("0.5")
+	dm.checkReplicas(context.Background())
+	if dm.Reader() == dm.Writer() {
+		t.Error("Reader() should use the replica once it caught up")
+	}
+	if status := dm.Replicas()[0]; !status.Healthy || status.Lag != 500*time.Millisecond {
+		t.Errorf("Replicas() = %+v, want a healthy replica", status)
+	}
+	if !strings.Contains(logs.String(), "Database replica available again") {
+		t.Errorf("the recovery was not logged:\n%s", logs)
+	}
+}
+
+// TestReplicationLag tests the lag of standbys in every replication state
+func TestReplicationLag(t *testing.T) {
+	age := func(seconds float64) *float64 { return &seconds }
+	tests := []struct {
+		name                               string
+		inRecovery, replayedAll, streaming bool
+		replayAge                          *float64
+		want                               time.Duration
+		wantErr                            bool
+	}{
+		{name: "promoted", replayAge: age(3600)},
+		{name: "streaming and caught up", inRecovery: true, replayedAll: true, streaming: true, replayAge: age(3600)},
+		{name: "streaming and replaying", inRecovery: true, streaming: true, replayAge: age(2), want: 2 * time.Second},
+		{name: "caught up but not streaming", inRecovery: true, replayedAll: true, replayAge: age(3600), want: time.Hour},
+		{name: "not streaming, nothing replayed", inRecovery: true, replayedAll: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replicationLag(tt.inRecovery, tt.replayedAll, tt.streaming, tt.replayAge)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("replicationLag() = %s, %v, want %s, error %t", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestReplicaNotStreaming tests that a standby disconnected from the primary is skipped
+// when it has replayed everything it received long ago, or nothing
+func TestReplicaNotStreaming(t *testing.T) {
+	primary := startFakeServer(t, func(int32) string { return "" })
+	standby := startFakeServer(t, func(int32) string { return "" })
+	var row atomic.Value
+	row.Store([]string{"t", "t", "f", "3600"})
+	standby.rowsWith(func(query string) []string {
+		if query == replicaLagQuery {
+			return row.Load().([]string)
+		}
+		return nil
+	})
+
+	dm, err := NewManager(primary.config(),
+		WithReplicas(ReplicaOptions{CheckPeriod: time.Hour, MaxLag: 5 * time.Second}, standby.config()))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	if status := dm.Replicas()[0]; status.Healthy || status.Lag != time.Hour {
+		t.Errorf("Replicas() = %+v, want a 1h lag", status)
+	}
+
+	row.Store([]string{"t", "t", "f", "NULL"})
+	dm.checkReplicas(context.Background())
+	if status := dm.Replicas()[0]; status.Healthy || status.LastError == nil || !strings.Contains(status.LastError.Error(), "unknown") {
+		t.Errorf("Replicas() = %+v, want an unknown lag", status)
+	}
+
+	row.Store([]string{"t", "t", "t", "3600"})
+	dm.checkReplicas(context.Background())
+	if status := dm.Replicas()[0]; !status.Healthy || status.Lag != 0 {
+		t.Errorf("Replicas() = %+v, want a streaming replica up to date", status)
+	}
+}
+
+// TestReplicaCredentials tests that only the replicas without a password of their own
+// get the credentials of the primary, and that replicas cannot be configured twice
+func TestReplicaCredentials(t *testing.T) {
+	primary := startFakeServer(t, func(int32) string { return "" })
+	server := startFakeServer(t, func(int32) string { return "" })
+	own := server.config()
+	shared := server.config()
+	shared.Password = ""
+	provider := CredentialsProviderFunc(func(context.Context) (Credentials, error) {
+		return Credentials{Password: "rotated"}, nil
+	})
+
+	dm, err := NewManager(primary.config(), WithCredentialsProvider(provider),
+		WithReplicas(ReplicaOptions{CheckPeriod: time.Hour}, own, shared))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+	if dm.replicas[0].pool.Config().BeforeConnect != nil {
+		t.Error("the replica with a password should not use the credentials provider")
+	}
+	if dm.replicas[1].pool.Config().BeforeConnect == nil {
+		t.Error("the replica without a password should use the credentials provider")
+	}
+
+	twice := WithReplicas(ReplicaOptions{CheckPeriod: time.Hour}, own)
+	if err := twice.AfterConnect(context.Background(), dm); err == nil {
+		t.Error("applying WithReplicas twice should fail")
+	}
+	if len(dm.replicas) != 2 {
+		t.Errorf("got %d replicas after the second WithReplicas, want 2", len(dm.replicas))
+	}
+}
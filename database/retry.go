@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/logger"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy configures how NewManager retries the connection check while the
+// database is not ready yet. Zero fields take the defaults in parentheses.
+type RetryPolicy struct {
+	Timeout        time.Duration // total time allowed for all attempts (1m)
+	InitialBackoff time.Duration // wait after the first failed attempt (500ms)
+	MaxBackoff     time.Duration // longest wait between two attempts (10s)
+	Multiplier     float64       // growth of the wait after each attempt (2)
+	// Jitter is the random fraction added or removed from each wait, from 0 to 1, set with
+	// JitterOf. Unlike the other fields, 0 is kept: JitterOf(0) disables the jitter and
+	// only nil takes the default (0.2).
+	Jitter *float64
+}
+
+// DefaultRetryPolicy returns the retry policy with every default applied
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Timeout:        time.Minute,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         JitterOf(0.2),
+	}
+}
+
+// JitterOf returns jitter for RetryPolicy.Jitter, JitterOf(0) disabling the jitter
+func JitterOf(jitter float64) *float64 {
+	return &jitter
+}
+
+// withDefaults returns p with the defaults of its zero fields
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.Timeout <= 0 {
+		p.Timeout = defaults.Timeout
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaults.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaults.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaults.Multiplier
+	}
+	if p.Jitter == nil || *p.Jitter < 0 || *p.Jitter > 1 {
+		p.Jitter = defaults.Jitter
+	} else {
+		p.Jitter = JitterOf(*p.Jitter)
+	}
+	return p
+}
+
+// backoff returns the wait after the given failed attempt, counted from 1, before jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < attempt && wait < float64(p.MaxBackoff); i++ {
+		wait *= p.Multiplier
+	}
+	return time.Duration(min(wait, float64(p.MaxBackoff)))
+}
+
+// jitter spreads wait by up to p.Jitter in both directions, so that the replicas of a
+// service do not reconnect in lockstep. p must have its defaults.
+func (p RetryPolicy) jitter(wait time.Duration) time.Duration {
+	return time.Duration(float64(wait) * (1 + *p.Jitter*(2*rand.Float64()-1))) // #nosec G404 -- not security sensitive
+}
+
+// WithStartupRetry is an option to retry the connection check of NewManagerContext with
+// exponential backoff while the database is not ready, for example while its container
+// starts. Each failed attempt is logged. Errors that retrying cannot fix, such as a wrong
+// password or an unknown database, fail immediately, see IsRetriableConnectError.
+// Retries stop when policy.Timeout elapses or when the context is done.
+func WithStartupRetry(policy RetryPolicy) ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		policy := policy.withDefaults()
+		dm.retry = &policy
+		return nil
+	})
+}
+
+// connect checks the connection, retrying as configured by WithStartupRetry
+func (dm *Manager) connect(ctx context.Context, connectTimeout time.Duration) error {
+	if dm.retry == nil {
+		ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+		return dm.db.PingContext(ctx)
+	}
+
+	policy := *dm.retry
+	ctx, cancel := context.WithTimeout(ctx, policy.Timeout)
+	defer cancel()
+	log := logger.GetLogger().With("database", dm.config.PublicConnectionString())
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, connectTimeout)
+		err := dm.db.PingContext(attemptCtx)
+		cancelAttempt()
+		if err == nil {
+			if attempt > 1 {
+				log.Info("Connected to database", "attempts", attempt)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		if !IsRetriableConnectError(err) {
+			return err
+		}
+
+		wait := policy.jitter(policy.backoff(attempt))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		log.Warn("Database not ready, retrying", "attempt", attempt, "backoff", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retriableSQLStates are the PostgreSQL error classes and codes of a server that is not
+// ready to accept connections yet, or not anymore
+var retriableSQLStates = []string{
+	"08",    // connection_exception
+	"53",    // insufficient_resources, such as too_many_connections
+	"57P01", // admin_shutdown
+	"57P02", // crash_shutdown
+	"57P03", // cannot_connect_now, the server is starting up
+}
+
+// IsRetriableConnectError reports whether err, returned while connecting, may go away
+// by trying again later: the server refuses or drops connections, is starting up, has
+// too many clients, its host name does not resolve yet, or it does not answer in time.
+// Other errors, such as a wrong password, an unknown database or a TLS handshake or
+// certificate failure, are fatal.
+func IsRetriableConnectError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		for _, state := range retriableSQLStates {
+			if strings.HasPrefix(pgErr.Code, state) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &alertErr), errors.As(err, &recordErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &invalidErr), errors.As(err, &hostnameErr):
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsNotFound || dnsErr.IsTemporary || dnsErr.IsTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case pgconn.Timeout(err), errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
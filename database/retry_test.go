@@ -0,0 +1,183 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/config"
+	"github.com/pixime-net/mapbot-shared/logger"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// captureLogs sends the logs of the test to the returned buffer
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	t.Cleanup(func() { logger.Logger = previous })
+	return &buf
+}
+
+// fastRetry retries quickly for the tests
+var fastRetry = RetryPolicy{Timeout: 2 * time.Second, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+// TestStartupRetry tests that NewManager waits for a starting server and gives up on fatal errors
+func TestStartupRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		reject       func(n int32) string
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "starting up", reject: func(n int32) string {
+			if n <= 2 {
+				return "57P03"
+			}
+			return ""
+		}, wantAttempts: 3},
+		{name: "too many clients", reject: func(n int32) string {
+			if n == 1 {
+				return "53300"
+			}
+			return ""
+		}, wantAttempts: 2},
+		{name: "bad password", reject: func(int32) string { return "28P01" }, wantErr: true, wantAttempts: 1},
+		{name: "unknown database", reject: func(int32) string { return "3D000" }, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			server := startFakeServer(t, tt.reject)
+
+			dm, err := NewManager(server.config(), WithStartupRetry(fastRetry))
+			if tt.wantErr {
+				if err == nil {
+					_ = dm.Close()
+					t.Fatal("NewManager() should fail")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("NewManager() error = %v", err)
+				}
+				_ = dm.Close()
+			}
+			if got := server.connections.Load(); got != tt.wantAttempts {
+				t.Errorf("connection attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if got := strings.Count(logs.String(), "Database not ready, retrying"); got != int(tt.wantAttempts)-1 {
+				t.Errorf("retries logged = %d, want %d:\n%s", got, tt.wantAttempts-1, logs)
+			}
+		})
+	}
+}
+
+// TestStartupRetryGivesUp tests that retries stop at the timeout and when the context is done
+func TestStartupRetryGivesUp(t *testing.T) {
+	captureLogs(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	_ = listener.Close() // connections are now refused
+	cfg := config.NewPostgresDatabase(addr.IP.String(), addr.Port, "mapbot", "mapbot", "secret")
+	cfg.MaxIdleConns = 0
+
+	policy := fastRetry
+	policy.Timeout = 300 * time.Millisecond
+	start := time.Now()
+	_, err = NewManager(cfg, WithStartupRetry(policy))
+	if err == nil || !strings.Contains(err.Error(), "attempts") {
+		t.Errorf("NewManager() error = %v, want to give up after several attempts", err)
+	}
+	if elapsed := time.Since(start); elapsed < policy.Timeout || elapsed > policy.Timeout+time.Second {
+		t.Errorf("NewManager() gave up after %s, want %s", elapsed, policy.Timeout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = NewManagerContext(ctx, cfg, WithStartupRetry(DefaultRetryPolicy()))
+	if err == nil {
+		t.Error("NewManager() should fail once the context is canceled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("NewManager() returned %s after the context was canceled", elapsed)
+	}
+}
+
+// TestRetryPolicyBackoff tests the exponential backoff and its jitter
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+	for attempt, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond,
+		400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if got := policy.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got := policy.jitter(time.Second); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s) = %s, want within 20%%", got)
+		}
+	}
+
+	policy = RetryPolicy{Jitter: JitterOf(0)}.withDefaults()
+	for i := 0; i < 10; i++ {
+		if got := policy.jitter(time.Second); got != time.Second {
+			t.Fatalf("jitter(1s) without jitter = %s", got)
+		}
+	}
+}
+
+// TestIsRetriableConnectError tests the classification of connection errors
+func TestIsRetriableConnectError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"host not found yet", &net.DNSError{Name: "postgres", IsNotFound: true}, true},
+		{"dropped connection", fmt.Errorf("receive message: %w", io.ErrUnexpectedEOF), true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"starting up", &pgconn.PgError{Code: "57P03"}, true},
+		{"too many clients", &pgconn.PgError{Code: "53300"}, true},
+		{"bad password", &pgconn.PgError{Code: "28P01"}, false},
+		{"unknown database", &pgconn.PgError{Code: "3D000"}, false},
+		{"network timeout", &net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{"network failure", &net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}, false},
+		{"tls alert", &net.OpError{Op: "remote error", Err: tls.AlertError(42)}, false},
+		{"unknown authority", fmt.Errorf("tls error: %w", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), false},
+		{"wrong host name", x509.HostnameError{Host: "postgres"}, false},
+		{"other", errors.New("tls: bad certificate"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetriableConnectError(tt.err); got != tt.want {
+				t.Errorf("IsRetriableConnectError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
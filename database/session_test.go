@@ -0,0 +1,68 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/database"
+	"github.com/pixime-net/mapbot-shared/testutils"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// sessionQuery reads back the session settings of a connection
+const sessionQuery = `SELECT current_setting('application_name'), current_setting('search_path'),
+	current_setting('statement_timeout'), current_setting('lock_timeout'), current_setting('work_mem')`
+
+// TestSessionSettings tests that both pools apply the session settings
+func TestSessionSettings(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	pgContainer, err := testutils.SetupPostGISContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start PostGIS container: %v", err)
+	}
+	t.Cleanup(func() { _ = pgContainer.Terminate(context.Background()) })
+	cfg, err := pgContainer.Config(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get container configuration: %v", err)
+	}
+	cfg.ApplicationName = "mapbot-session-test"
+	cfg.SearchPath = "admin, public"
+	cfg.StatementTimeout = 30 * time.Second
+	cfg.LockTimeout = 2 * time.Second
+	cfg.RuntimeParams = map[string]string{"work_mem": "64MB"}
+
+	dm, err := database.NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	want := [5]string{"mapbot-session-test", "admin, public", "30s", "2s", "64MB"}
+	var fromDB, fromPool [5]string
+	if err := dm.GetDB().QueryRowContext(ctx, sessionQuery).Scan(&fromDB[0], &fromDB[1], &fromDB[2], &fromDB[3], &fromDB[4]); err != nil {
+		t.Fatalf("database/sql query error = %v", err)
+	}
+	if err := dm.GetPool().QueryRow(ctx, sessionQuery).Scan(&fromPool[0], &fromPool[1], &fromPool[2], &fromPool[3], &fromPool[4]); err != nil {
+		t.Fatalf("pgxpool query error = %v", err)
+	}
+	if fromDB != want {
+		t.Errorf("database/sql session settings = %q, want %q", fromDB, want)
+	}
+	if fromPool != want {
+		t.Errorf("pgxpool session settings = %q, want %q", fromPool, want)
+	}
+
+	// statement_timeout is enforced
+	next := *cfg
+	next.StatementTimeout = 100 * time.Millisecond
+	if err := dm.ApplyConfig(&next); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	if _, err := dm.GetPool().Exec(ctx, "SELECT pg_sleep(1)"); err == nil {
+		t.Error("a query longer than statement_timeout should be canceled")
+	}
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultSlowQueryThreshold is the duration above which WithSlowQueryLog logs a query
+// when SlowQueryOptions.Threshold is zero
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// SlowQueryOptions configure the slow query log of WithSlowQueryLog
+type SlowQueryOptions struct {
+	// Threshold is the duration above which a query is slow, DefaultSlowQueryThreshold if zero
+	Threshold time.Duration
+	// SampleRate is the fraction of the slow queries logged, between 0 and 1, all of them if
+	// zero. With 0.1, one slow query in ten is logged.
+	SampleRate float64
+	// LogArguments logs the values of the query arguments, which may be sensitive. Only
+	// their count is logged otherwise.
+	LogArguments bool
+}
+
+// WithSlowQueryLog is an option to log as warnings the queries run through GetPool, GetDB,
+// Reader and ReaderDB that take longer than the threshold of opts, with their duration,
+// SQL text without literal values, argument count, affected rows and the code running
+// them. For queries returning rows, the code is the one closing the rows.
+func WithSlowQueryLog(opts SlowQueryOptions) ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		if opts.Threshold < 0 {
+			return fmt.Errorf("slow query threshold cannot be negative: %s", opts.Threshold)
+		}
+		if opts.SampleRate < 0 || opts.SampleRate > 1 {
+			return fmt.Errorf("slow query sample rate must be between 0 and 1: %g", opts.SampleRate)
+		}
+		if opts.Threshold == 0 {
+			opts.Threshold = DefaultSlowQueryThreshold
+		}
+		if opts.SampleRate == 0 {
+			opts.SampleRate = 1
+		}
+		dm.tracers = append(dm.tracers, &slowQueryTracer{
+			options:  opts,
+			database: dm.config.Database,
+		})
+		return nil
+	})
+}
+
+// slowQueryTracer logs the slow queries of a manager
+type slowQueryTracer struct {
+	options  SlowQueryOptions
+	database string
+	slow     atomic.Uint64 // count of slow queries, for sampling
+}
+
+// slowQueryKey is the context key of the start of a query timed by slowQueryTracer
+type slowQueryKey struct{}
+
+// slowQueryStart is the start of a query timed by slowQueryTracer
+type slowQueryStart struct {
+	at   time.Time
+	sql  string
+	args []any
+}
+
+func (t *slowQueryTracer) start(ctx context.Context, sql string, args []any) context.Context {
+	return context.WithValue(ctx, slowQueryKey{}, slowQueryStart{at: time.Now(), sql: sql, args: args})
+}
+
+func (t *slowQueryTracer) end(ctx context.Context, conn *pgx.Conn, tag pgconn.CommandTag, err error) {
+	start, ok := ctx.Value(slowQueryKey{}).(slowQueryStart)
+	if !ok {
+		return
+	}
+	duration := time.Since(start.at)
+	if duration < t.options.Threshold || !t.sample() {
+		return
+	}
+
+	attrs := []any{
+		"database", t.database,
+		"duration", duration,
+		"sql", sanitizeSQL(start.sql),
+		"args", len(start.args),
+		"rows", tag.RowsAffected(),
+	}
+	if conn != nil {
+		host, port := serverAddress(conn)
+		if port != 0 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		attrs = append(attrs, "server", host)
+	}
+	if t.options.LogArguments {
+		attrs = append(attrs, "arguments", start.args)
+	}
+	if caller := queryCaller(); caller != "" {
+		attrs = append(attrs, "caller", caller)
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.GetLogger().Warn("Slow database query", attrs...)
+}
+
+// sample tells whether the current slow query is logged, spreading the logged ones evenly
+// at the sample rate
+func (t *slowQueryTracer) sample() bool {
+	n := float64(t.slow.Add(1))
+	rate := t.options.SampleRate
+	return math.Floor(n*rate) > math.Floor((n-1)*rate)
+}
+
+// queryCaller returns the file and line of the first caller outside pgx, database/sql and
+// this package, empty if none
+func queryCaller() string {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if !isQueryInternal(frame) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// isQueryInternal tells whether frame belongs to the code running a query for its caller
+func isQueryInternal(frame runtime.Frame) bool {
+	for _, prefix := range []string{"github.com/jackc/", "database/sql", "runtime.", "context."} {
+		if strings.HasPrefix(frame.Function, prefix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(frame.Function, "github.com/pixime-net/mapbot-shared/database.") &&
+		!strings.HasSuffix(frame.File, "_test.go")
+}
+
+// queryArguments returns args without the options of pgx preceding them, such as
+// QueryExecModeSimpleProtocol
+func queryArguments(args []any) []any {
+	for len(args) > 0 {
+		switch args[0].(type) {
+		case pgx.QueryExecMode, pgx.QueryResultFormats, pgx.QueryResultFormatsByOID:
+			args = args[1:]
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, data.SQL, queryArguments(data.Args))
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, conn, data.CommandTag, data.Err)
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer
+func (t *slowQueryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx, "COPY "+data.TableName.Sanitize()+" FROM STDIN", nil)
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer
+func (t *slowQueryTracer) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, conn, data.CommandTag, data.Err)
+}
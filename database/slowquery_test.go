@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TestSlowQueryLog tests the logging of the slow queries of both APIs
+func TestSlowQueryLog(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	server := startFakeServer(t, func(int32) string { return "" })
+	port := server.config().Port
+	tests := []struct {
+		name    string
+		options SlowQueryOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "fast queries",
+			options: SlowQueryOptions{Threshold: time.Minute},
+			notWant: []string{"Slow database query"},
+		},
+		{
+			name:    "slow queries",
+			options: SlowQueryOptions{Threshold: time.Nanosecond},
+			want: []string{
+				`level=WARN msg="Slow database query" database=mapbot duration=`,
+				`sql="UPDATE tiles SET name = $1 WHERE id = ?" args=1 rows=0 server=127.0.0.1:` + strconv.Itoa(port) + " ",
+				`sql="INSERT INTO tiles (z) VALUES (?)" args=0`,
+				"caller=" + file + ":",
+			},
+			notWant: []string{"Lyon", "arguments="},
+		},
+		{
+			name:    "arguments",
+			options: SlowQueryOptions{Threshold: time.Nanosecond, LogArguments: true},
+			want:    []string{"arguments=[Lyon]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, err := NewManager(server.config(), WithSlowQueryLog(tt.options))
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			defer func() { _ = dm.Close() }()
+			logs := captureLogs(t)
+
+			ctx := context.Background()
+			if _, err := dm.GetPool().Exec(ctx, "UPDATE tiles SET name = $1 WHERE id = 42", pgx.QueryExecModeSimpleProtocol, "Lyon"); err != nil {
+				t.Fatalf("pgxpool Exec() error = %v", err)
+			}
+			if _, err := dm.GetDB().ExecContext(ctx, "INSERT INTO tiles (z) VALUES (3)"); err != nil {
+				t.Fatalf("database/sql Exec() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs do not contain %q:\n%s", want, logs)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(logs.String(), notWant) {
+					t.Errorf("logs contain %q:\n%s", notWant, logs)
+				}
+			}
+		})
+	}
+}
+
+// TestSlowQuerySampling tests that the sampled slow queries are spread evenly
+func TestSlowQuerySampling(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want string
+	}{
+		{rate: 1, want: "1111111111"},
+		{rate: 0.5, want: "0101010101"},
+		{rate: 0.25, want: "0001000100"},
+		{rate: 0.1, want: "0000000001"},
+	}
+	for _, tt := range tests {
+		tracer := &slowQueryTracer{options: SlowQueryOptions{SampleRate: tt.rate}}
+		var got strings.Builder
+		for range len(tt.want) {
+			if tracer.sample() {
+				got.WriteByte('1')
+			} else {
+				got.WriteByte('0')
+			}
+		}
+		if got.String() != tt.want {
+			t.Errorf("sample() at rate %g = %s, want %s", tt.rate, got.String(), tt.want)
+		}
+	}
+}
+
+// TestWithSlowQueryLogValidation tests the rejected options
+func TestWithSlowQueryLogValidation(t *testing.T) {
+	for _, options := range []SlowQueryOptions{
+		{Threshold: -time.Second},
+		{SampleRate: 1.5},
+		{SampleRate: -0.1},
+	} {
+		if err := WithSlowQueryLog(options).BeforeConnect(context.Background(), &Manager{}); err == nil {
+			t.Errorf("WithSlowQueryLog(%+v) should fail", options)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sqlOperations are the statement keywords reported by sqlOperation, others are OTHER
+var sqlOperations = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "WITH": true,
+	"COPY": true, "VALUES": true, "CALL": true, "DO": true, "EXPLAIN": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "COMMENT": true, "GRANT": true, "REVOKE": true,
+	"SET": true, "RESET": true, "SHOW": true, "LOCK": true, "LISTEN": true, "UNLISTEN": true, "NOTIFY": true,
+	"REFRESH": true, "ANALYZE": true, "VACUUM": true, "CLUSTER": true, "REINDEX": true,
+	"PREPARE": true, "EXECUTE": true, "DEALLOCATE": true, "DECLARE": true, "FETCH": true, "MOVE": true, "CLOSE": true,
+}
+
+// sqlOperation returns the keyword starting the statement sql in upper case, such as
+// SELECT, skipping comments. Unknown keywords are reported as OTHER, so that the result
+// can label metrics.
+func sqlOperation(sql string) string {
+	sql = skipComments(sql)
+	end := strings.IndexFunc(sql, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(sql)
+	}
+	if keyword := strings.ToUpper(sql[:end]); sqlOperations[keyword] {
+		return keyword
+	}
+	return "OTHER"
+}
+
+// skipComments returns sql without its leading spaces and comments
+func skipComments(sql string) string {
+	for {
+		sql = strings.TrimLeftFunc(sql, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			end := strings.IndexByte(sql, '\n')
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+1:]
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+2:]
+		default:
+			return sql
+		}
+	}
+}
+
+// sanitizeSQL returns sql with its literal values, strings and numbers, replaced by ?, so
+// that it can be recorded without the data it may hold. Identifiers, comments and
+// parameters such as $1 are kept.
+func sanitizeSQL(sql string) string {
+	out := make([]byte, 0, len(sql))
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			backslashes := false
+			if isEscapePrefix(out) {
+				// E'...', B'...' or X'...': the prefix belongs to the literal
+				backslashes = out[len(out)-1]|0x20 == 'e'
+				out = out[:len(out)-1]
+			}
+			i = skipQuoted(sql, i, backslashes)
+			out = append(out, '?')
+		case c == '"':
+			end := strings.IndexByte(sql[i+1:], '"')
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+			out = append(out, sql[i:i+end+2]...)
+			i += end + 2
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			out = append(out, sql[i:i+end]...)
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 4
+			}
+			out = append(out, sql[i:i+end+4]...)
+			i += end + 4
+		case c == '$' && !endsWithIdentifier(out):
+			if tag := dollarTag(sql[i:]); tag != "" {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					end = len(sql) - i - 2*len(tag)
+				}
+				out = append(out, '?')
+				i += end + 2*len(tag)
+			} else {
+				// parameter, such as $1
+				out = append(out, c)
+				i++
+				for i < len(sql) && isDigit(sql[i]) {
+					out = append(out, sql[i])
+					i++
+				}
+			}
+		case (isDigit(c) || c == '.' && i+1 < len(sql) && isDigit(sql[i+1])) && !endsWithIdentifier(out):
+			i = skipNumber(sql, i)
+			out = append(out, '?')
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return string(out)
+}
+
+// isEscapePrefix tells whether written ends with the E, B or X prefix of a string literal
+func isEscapePrefix(written []byte) bool {
+	if len(written) == 0 {
+		return false
+	}
+	switch written[len(written)-1] | 0x20 {
+	case 'e', 'b', 'x':
+		return len(written) == 1 || !isIdentifier(written[len(written)-2])
+	}
+	return false
+}
+
+// skipQuoted returns the index following the string literal starting at sql[start], in
+// which quotes are doubled and, if backslashes is set, escaped by a backslash
+func skipQuoted(sql string, start int, backslashes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case backslashes && sql[i] == '\\':
+			i++
+		case sql[i] == '\'':
+			if i+1 < len(sql) && sql[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string starting sql, such as $$
+// or $body$, and an empty string if sql does not start with one
+func dollarTag(sql string) string {
+	for i := 1; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '$':
+			return sql[:i+1]
+		case isDigit(c) && i == 1, !isIdentifier(c):
+			return ""
+		}
+	}
+	return ""
+}
+
+// skipNumber returns the index following the numeric literal starting at sql[start]
+func skipNumber(sql string, start int) int {
+	i := start
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+	}
+	for i < len(sql) {
+		switch c := sql[i]; {
+		case isIdentifier(c) || c == '.':
+			i++
+		case (c == '+' || c == '-') && sql[i-1]|0x20 == 'e' && !strings.HasPrefix(sql[start:], "0x"):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// endsWithIdentifier tells whether the last byte of written belongs to an identifier
+func endsWithIdentifier(written []byte) bool {
+	return len(written) > 0 && isIdentifier(written[len(written)-1])
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c|0x20 >= 'a' && c|0x20 <= 'z' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
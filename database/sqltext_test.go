@@ -0,0 +1,49 @@
+package database
+
+import "testing"
+
+// TestSQLOperation tests the statement keywords used as metric labels
+func TestSQLOperation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1", "SELECT"},
+		{"  select * from tiles", "SELECT"},
+		{"-- tiles of a zoom level\nWITH t AS (SELECT 1) SELECT * FROM t", "WITH"},
+		{"/* import */ INSERT INTO tiles DEFAULT VALUES", "INSERT"},
+		{"begin isolation level serializable", "BEGIN"},
+		{"VACUUM(ANALYZE) tiles", "VACUUM"},
+		{"frobnicate", "OTHER"},
+		{"", "OTHER"},
+		{"-- only a comment", "OTHER"},
+	}
+	for _, tt := range tests {
+		if got := sqlOperation(tt.sql); got != tt.want {
+			t.Errorf("sqlOperation(%q) = %s, want %s", tt.sql, got, tt.want)
+		}
+	}
+}
+
+// TestSanitizeSQL tests the removal of the literal values of statements
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM tiles WHERE id = $1", "SELECT * FROM tiles WHERE id = $1"},
+		{"UPDATE tiles SET name = 'Lyon' WHERE id = 42", "UPDATE tiles SET name = ? WHERE id = ?"},
+		{"SELECT 'it''s', E'a\\'b', x'1F', 1.5e-3, .5, -7", "SELECT ?, ?, ?, ?, ?, -?"},
+		{"SELECT $$secret$$, $body$ 'quoted' $body$", "SELECT ?, ?"},
+		{`SELECT "col 1", t2.z3, tile$1 FROM "Tiles's"`, `SELECT "col 1", t2.z3, tile$1 FROM "Tiles's"`},
+		{"SELECT ST_Buffer(geom, 10)::geometry(Polygon, 4326)", "SELECT ST_Buffer(geom, ?)::geometry(Polygon, ?)"},
+		{"-- zoom 3\nSELECT /* 'kept' */ 3", "-- zoom 3\nSELECT /* 'kept' */ ?"},
+		{"SELECT 'unterminated", "SELECT ?"},
+		{"SELECT 0xFF, LIKE'x'", "SELECT ?, LIKE?"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeSQL(tt.sql); got != tt.want {
+			t.Errorf("sanitizeSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans
+const tracerName = "github.com/pixime-net/mapbot-shared/database"
+
+// spanAttributesKey is the pgconn custom data key caching the attributes of a connection
+const spanAttributesKey = "mapbot.spanAttributes"
+
+// OTelTracer records the queries of pgx connections as OpenTelemetry client spans,
+// following the semantic conventions of database spans. Query texts are sanitized:
+// literal values are replaced by ?, and arguments are never recorded.
+type OTelTracer struct {
+	tracer trace.Tracer
+}
+
+// NewOTelTracer creates a tracer whose spans are created by provider, the global
+// provider of otel if nil
+func NewOTelTracer(provider trace.TracerProvider) *OTelTracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &OTelTracer{tracer: provider.Tracer(tracerName)}
+}
+
+// WithTracing is an option to trace the queries run through GetPool, GetDB, Reader and
+// ReaderDB with spans created by provider, the global provider of otel if nil. The spans
+// of a request are children of the span in the context of the query.
+func WithTracing(provider trace.TracerProvider) ManagerOption {
+	return connectOption(func(_ context.Context, dm *Manager) error {
+		dm.tracers = append(dm.tracers, NewOTelTracer(provider))
+		return nil
+	})
+}
+
+// OpenTracedDB opens a database/sql database whose queries are traced by t, for the
+// databases that are not opened by a Manager: GetDB is traced by WithTracing already.
+// A tracer in connConfig is kept.
+func (t *OTelTracer) OpenTracedDB(connConfig pgx.ConnConfig, opts ...stdlib.OptionOpenDB) *sql.DB {
+	if connConfig.Tracer != nil {
+		connConfig.Tracer = multitracer.New(connConfig.Tracer, t)
+	} else {
+		connConfig.Tracer = t
+	}
+	return stdlib.OpenDB(connConfig, opts...)
+}
+
+// spanKey is the context key of the span of a traced query
+type spanKey struct{}
+
+// start starts the span of a query on conn, named after its operation
+func (t *OTelTracer) start(ctx context.Context, conn *pgx.Conn, operation string, attrs ...attribute.KeyValue) context.Context {
+	name := operation
+	if operation == "OTHER" {
+		name = "postgresql"
+	} else {
+		attrs = append(attrs, semconv.DBOperationName(operation))
+	}
+	attrs = append(attrs, connAttributes(conn)...)
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// end ends the span started in ctx, recording the rows of tag and err, if not nil
+func (t *OTelTracer) end(ctx context.Context, tag pgconn.CommandTag, err error) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if err != nil {
+		recordError(span, err)
+	} else {
+		span.SetAttributes(rowAttributes(tag)...)
+	}
+	span.End()
+}
+
+// connAttributes returns the attributes of the server of conn, computed once per connection
+func connAttributes(conn *pgx.Conn) []attribute.KeyValue {
+	if conn == nil {
+		return []attribute.KeyValue{semconv.DBSystemNamePostgreSQL}
+	}
+	data := conn.PgConn().CustomData()
+	if attrs, ok := data[spanAttributesKey].([]attribute.KeyValue); ok {
+		return attrs
+	}
+	host, port := serverAddress(conn)
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemNamePostgreSQL,
+		semconv.DBNamespace(conn.Config().Database),
+		semconv.ServerAddress(host),
+	}
+	if port != 0 {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	data[spanAttributesKey] = attrs
+	return attrs
+}
+
+// serverAddress returns the address and port of the server conn is connected to, which
+// with fallback hosts is the one that accepted it, or the socket path and 0 for a Unix
+// socket
+func serverAddress(conn *pgx.Conn) (string, int) {
+	switch addr := conn.PgConn().Conn().RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String(), addr.Port
+	case *net.UnixAddr:
+		return addr.Name, 0
+	case nil:
+		return "", 0
+	default:
+		host, port, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return addr.String(), 0
+		}
+		n, _ := strconv.Atoi(port)
+		return host, n
+	}
+}
+
+// rowAttributes returns the row count of tag: the rows returned by a SELECT, the rows
+// written by other statements reporting a count
+func rowAttributes(tag pgconn.CommandTag) []attribute.KeyValue {
+	switch {
+	case tag.Select():
+		return []attribute.KeyValue{semconv.DBResponseReturnedRows(int(tag.RowsAffected()))}
+	case tag.Insert(), tag.Update(), tag.Delete(), tag.RowsAffected() > 0:
+		return []attribute.KeyValue{attribute.Int64("db.response.affected_rows", tag.RowsAffected())}
+	}
+	return nil
+}
+
+// recordError sets the status of span to the error err, with its SQLSTATE if any
+func recordError(span trace.Span, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		span.SetAttributes(semconv.DBResponseStatusCode(pgErr.Code), semconv.ErrorTypeKey.String(pgErr.Code))
+	} else {
+		span.SetAttributes(semconv.ErrorType(err))
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (t *OTelTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, conn, sqlOperation(data.SQL), semconv.DBQueryText(sanitizeSQL(data.SQL)))
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (t *OTelTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.CommandTag, data.Err)
+}
+
+// TraceBatchStart implements pgx.BatchTracer, a batch being one BATCH span whose queries
+// are events
+func (t *OTelTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, conn, "BATCH", semconv.DBOperationBatchSize(data.Batch.Len()))
+}
+
+// TraceBatchQuery implements pgx.BatchTracer
+func (t *OTelTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	attrs := []attribute.KeyValue{semconv.DBQueryText(sanitizeSQL(data.SQL))}
+	if operation := sqlOperation(data.SQL); operation != "OTHER" {
+		attrs = append(attrs, semconv.DBOperationName(operation))
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) {
+		attrs = append(attrs, semconv.DBResponseStatusCode(pgErr.Code))
+	} else if data.Err == nil {
+		attrs = append(attrs, rowAttributes(data.CommandTag)...)
+	}
+	span.AddEvent("query", trace.WithAttributes(attrs...))
+}
+
+// TraceBatchEnd implements pgx.BatchTracer
+func (t *OTelTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, pgconn.CommandTag{}, data.Err)
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer
+func (t *OTelTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx, conn, "COPY", semconv.DBCollectionName(data.TableName.Sanitize()))
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer
+func (t *OTelTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.CommandTag, data.Err)
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pixime-net/mapbot-shared/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestProvider returns a tracer provider exporting its spans to the returned exporter
+func newTestProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+	return provider, exporter
+}
+
+// spanNamed returns the span called name among spans
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no span %s in %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+// checkAttributes fails if attrs do not contain want
+func checkAttributes(t *testing.T, name string, attrs []attribute.KeyValue, want map[attribute.Key]any) {
+	t.Helper()
+	got := make(map[attribute.Key]any, len(attrs))
+	for _, attr := range attrs {
+		got[attr.Key] = attr.Value.AsInterface()
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s: attribute %s = %v, want %v", name, key, got[key], value)
+		}
+	}
+}
+
+// TestTracing tests the spans of queries run through both APIs
+func TestTracing(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	server.rowsWith(func(query string) []string {
+		if strings.HasPrefix(query, "SELECT") {
+			return []string{"1.5"}
+		}
+		return nil
+	})
+	server.failWith(func(query string) string {
+		if strings.HasPrefix(query, "DELETE") {
+			return "23503"
+		}
+		return ""
+	})
+	provider, exporter := newTestProvider(t)
+	dm, err := NewManager(server.config(), WithTracing(provider))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	if _, err := dm.GetPool().Exec(ctx, "UPDATE tiles SET name = 'Lyon' WHERE id = 42"); err != nil {
+		t.Fatalf("pgxpool Exec() error = %v", err)
+	}
+	var ratio float64
+	if err := dm.GetDB().QueryRowContext(ctx, "SELECT 1.5 AS ratio", pgx.QueryExecModeSimpleProtocol).Scan(&ratio); err != nil {
+		t.Fatalf("database/sql QueryRow() error = %v", err)
+	}
+	if _, err := dm.GetPool().Exec(ctx, "DELETE FROM tiles"); err == nil {
+		t.Fatal("DELETE should fail")
+	}
+	request.End()
+
+	spans := exporter.GetSpans()
+	conn := map[attribute.Key]any{
+		"db.system.name": "postgresql",
+		"db.namespace":   "mapbot",
+		"server.address": "127.0.0.1",
+		"server.port":    int64(server.config().Port),
+	}
+	for _, name := range []string{"UPDATE", "SELECT", "DELETE"} {
+		span := spanNamed(t, spans, name)
+		if span.SpanKind != trace.SpanKindClient {
+			t.Errorf("%s: kind = %v, want client", name, span.SpanKind)
+		}
+		if span.Parent.SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("%s: parent = %v, want the request span", name, span.Parent.SpanID())
+		}
+		checkAttributes(t, name, span.Attributes, conn)
+	}
+
+	update := spanNamed(t, spans, "UPDATE")
+	checkAttributes(t, "UPDATE", update.Attributes, map[attribute.Key]any{
+		"db.operation.name": "UPDATE",
+		"db.query.text":     "UPDATE tiles SET name = ? WHERE id = ?",
+	})
+	selection := spanNamed(t, spans, "SELECT")
+	checkAttributes(t, "SELECT", selection.Attributes, map[attribute.Key]any{
+		"db.query.text":             "SELECT ? AS ratio",
+		"db.response.returned_rows": int64(1),
+	})
+	deletion := spanNamed(t, spans, "DELETE")
+	checkAttributes(t, "DELETE", deletion.Attributes, map[attribute.Key]any{
+		"db.response.status_code": "23503",
+		"error.type":              "23503",
+	})
+	if deletion.Status.Code != codes.Error {
+		t.Errorf("DELETE: status = %v, want error", deletion.Status.Code)
+	}
+}
+
+// TestTracingFallbackHost tests that spans name the server that accepted the connection
+func TestTracingFallbackHost(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	cfg := server.config()
+	cfg.FallbackHosts = []config.HostPort{{Host: cfg.Host, Port: cfg.Port}}
+	cfg.Port = closed.Addr().(*net.TCPAddr).Port
+
+	provider, exporter := newTestProvider(t)
+	dm, err := NewManager(cfg, WithTracing(provider))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+	if _, err := dm.GetPool().Exec(context.Background(), "UPDATE tiles SET z = 3"); err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	checkAttributes(t, "UPDATE", spanNamed(t, exporter.GetSpans(), "UPDATE").Attributes, map[attribute.Key]any{
+		"server.address": "127.0.0.1",
+		"server.port":    int64(server.config().Port),
+	})
+}
+
+// TestOTelTracerBatchAndCopy tests the spans of batches and copies
+func TestOTelTracerBatchAndCopy(t *testing.T) {
+	provider, exporter := newTestProvider(t)
+	tracer := NewOTelTracer(provider)
+	ctx := context.Background()
+
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO tiles (z) VALUES (3)")
+	batch.Queue("UPDATE tiles SET z = $1", 4)
+	batchCtx := tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+	tracer.TraceBatchQuery(batchCtx, nil, pgx.TraceBatchQueryData{SQL: batch.QueuedQueries[0].SQL, CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+	failure := &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+	tracer.TraceBatchQuery(batchCtx, nil, pgx.TraceBatchQueryData{SQL: batch.QueuedQueries[1].SQL, Err: failure})
+	tracer.TraceBatchEnd(batchCtx, nil, pgx.TraceBatchEndData{Err: failure})
+
+	copyCtx := tracer.TraceCopyFromStart(ctx, nil, pgx.TraceCopyFromStartData{TableName: pgx.Identifier{"tiles"}})
+	tracer.TraceCopyFromEnd(copyCtx, nil, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag("COPY 3")})
+
+	spans := exporter.GetSpans()
+	batchSpan := spanNamed(t, spans, "BATCH")
+	checkAttributes(t, "BATCH", batchSpan.Attributes, map[attribute.Key]any{
+		"db.operation.batch.size": int64(2),
+		"db.response.status_code": "40P01",
+	})
+	if batchSpan.Status.Code != codes.Error {
+		t.Errorf("BATCH: status = %v, want error", batchSpan.Status.Code)
+	}
+	var queries [][]attribute.KeyValue
+	for _, event := range batchSpan.Events {
+		if event.Name == "query" {
+			queries = append(queries, event.Attributes)
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("BATCH: %d query events, want 2", len(queries))
+	}
+	checkAttributes(t, "BATCH query 1", queries[0], map[attribute.Key]any{
+		"db.query.text":             "INSERT INTO tiles (z) VALUES (?)",
+		"db.response.affected_rows": int64(1),
+		"db.operation.name":         "INSERT",
+	})
+	checkAttributes(t, "BATCH query 2", queries[1], map[attribute.Key]any{
+		"db.query.text":           "UPDATE tiles SET z = $1",
+		"db.response.status_code": "40P01",
+	})
+
+	checkAttributes(t, "COPY", spanNamed(t, spans, "COPY").Attributes, map[attribute.Key]any{
+		"db.operation.name":         "COPY",
+		"db.collection.name":        `"tiles"`,
+		"db.response.affected_rows": int64(3),
+	})
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pixime-net/mapbot-shared/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultTxMaxAttempts is the number of attempts of a transaction when TxOptions.MaxAttempts is zero
+const DefaultTxMaxAttempts = 3
+
+// TxOptions configures a transaction of WithTx and WithSQLTx
+type TxOptions struct {
+	IsoLevel   pgx.TxIsoLevel // isolation level, the server default when empty
+	ReadOnly   bool           // READ ONLY transaction
+	Deferrable bool           // DEFERRABLE transaction, only effective when serializable and read-only
+
+	// MaxAttempts bounds the attempts of a transaction that fails with a serialization
+	// failure or a deadlock (DefaultTxMaxAttempts when zero, 1 disables retries)
+	MaxAttempts int
+}
+
+// txRetryPolicy spaces the attempts of a transaction; conflicting transactions are
+// spread apart by the jitter
+var txRetryPolicy = RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, Jitter: JitterOf(0.5)}.withDefaults()
+
+// WithTx runs fn in a transaction of the pgxpool. The transaction is committed when fn
+// returns nil, and rolled back when it returns an error or panics, the panic being
+// propagated. When fn or the commit fails with a serialization failure (SQLSTATE 40001)
+// or a deadlock (40P01), the whole transaction is retried with a short backoff, up to
+// opts.MaxAttempts attempts, so fn must not have side effects outside the transaction.
+func (dm *Manager) WithTx(ctx context.Context, opts TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	pgxOpts := pgx.TxOptions{IsoLevel: opts.IsoLevel}
+	if opts.ReadOnly {
+		pgxOpts.AccessMode = pgx.ReadOnly
+	}
+	if opts.Deferrable {
+		pgxOpts.DeferrableMode = pgx.Deferrable
+	}
+	return retryTx(ctx, opts, func() error {
+		tx, err := dm.GetPool().BeginTx(ctx, pgxOpts)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		return runTx(ctx, tx, func() error { return fn(ctx, tx) })
+	})
+}
+
+// WithSQLTx is WithTx for database/sql callers, running fn in a transaction of GetDB
+func (dm *Manager) WithSQLTx(ctx context.Context, opts TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	sqlOpts := &sql.TxOptions{ReadOnly: opts.ReadOnly}
+	switch opts.IsoLevel {
+	case "":
+	case pgx.Serializable:
+		sqlOpts.Isolation = sql.LevelSerializable
+	case pgx.RepeatableRead:
+		sqlOpts.Isolation = sql.LevelRepeatableRead
+	case pgx.ReadCommitted:
+		sqlOpts.Isolation = sql.LevelReadCommitted
+	case pgx.ReadUncommitted:
+		sqlOpts.Isolation = sql.LevelReadUncommitted
+	default:
+		return fmt.Errorf("unsupported isolation level %q", opts.IsoLevel)
+	}
+	return retryTx(ctx, opts, func() error {
+		tx, err := dm.db.BeginTx(ctx, sqlOpts)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		return runTx(ctx, sqlTx{tx}, func() error {
+			// database/sql has no deferrable option, it is set before the first query
+			if opts.Deferrable {
+				if _, err := tx.ExecContext(ctx, "SET TRANSACTION DEFERRABLE"); err != nil {
+					return err
+				}
+			}
+			return fn(ctx, tx)
+		})
+	})
+}
+
+// transaction is the part of pgx.Tx and *sql.Tx that runTx needs
+type transaction interface {
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// sqlTx adapts *sql.Tx to transaction
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t sqlTx) Commit(context.Context) error   { return t.tx.Commit() }
+func (t sqlTx) Rollback(context.Context) error { return t.tx.Rollback() }
+
+// runTx runs fn in tx, committing when it succeeds and rolling back otherwise
+func runTx(ctx context.Context, tx transaction, fn func() error) (err error) {
+	// The rollback must reach the server even if ctx is the cause of the failure
+	rollbackCtx := context.WithoutCancel(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(rollbackCtx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		if rollbackErr := tx.Rollback(rollbackCtx); rollbackErr != nil &&
+			!errors.Is(rollbackErr, pgx.ErrTxClosed) && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// retryTx runs attempt until it succeeds, fails with an error that is not retriable,
+// or has run opts.MaxAttempts times
+func retryTx(ctx context.Context, opts TxOptions, attempt func() error) error {
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultTxMaxAttempts
+	}
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= maxAttempts || !IsRetriableTxError(err) {
+			return err
+		}
+
+		wait := txRetryPolicy.jitter(txRetryPolicy.backoff(n))
+		logger.GetLogger().Debug("Retrying transaction", "attempt", n, "backoff", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// IsRetriableTxError reports whether err is a serialization failure (SQLSTATE 40001) or
+// a deadlock (40P01), after which the whole transaction may succeed when run again
+func IsRetriableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// txAPI runs the statement update in a transaction of WithTx or WithSQLTx
+type txAPI func(ctx context.Context, dm *Manager, opts TxOptions, update string, fn func() error) error
+
+var txAPIs = map[string]txAPI{
+	"pgx": func(ctx context.Context, dm *Manager, opts TxOptions, update string, fn func() error) error {
+		return dm.WithTx(ctx, opts, func(ctx context.Context, tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, update); err != nil {
+				return err
+			}
+			return fn()
+		})
+	},
+	"database/sql": func(ctx context.Context, dm *Manager, opts TxOptions, update string, fn func() error) error {
+		return dm.WithSQLTx(ctx, opts, func(ctx context.Context, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, update); err != nil {
+				return err
+			}
+			return fn()
+		})
+	},
+}
+
+// TestWithTx tests the commit, rollback and retries of both transaction helpers
+func TestWithTx(t *testing.T) {
+	captureLogs(t)
+	errFn := errors.New("fn failed")
+	const update = "UPDATE tiles SET version = version + 1"
+
+	tests := []struct {
+		name    string
+		fail    func() func(query string) string // a new fail function for each API
+		fnErr   error
+		opts    TxOptions
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "commit",
+			want: []string{"begin", update, "commit"},
+		},
+		{
+			name:    "rollback on error",
+			fnErr:   errFn,
+			wantErr: true,
+			want:    []string{"begin", update, "rollback"},
+		},
+		{
+			name: "retry serialization failure at commit",
+			fail: failOnce("commit", "40001"),
+			opts: TxOptions{IsoLevel: pgx.Serializable},
+			want: []string{"begin isolation level serializable", update, "commit",
+				"begin isolation level serializable", update, "commit"},
+		},
+		{
+			name: "retry deadlock",
+			fail: failOnce(update, "40P01"),
+			want: []string{"begin", update, "rollback", "begin", update, "commit"},
+		},
+		{
+			name:    "bounded retries",
+			fail:    failAlways(update, "40001"),
+			opts:    TxOptions{MaxAttempts: 2},
+			wantErr: true,
+			want:    []string{"begin", update, "rollback", "begin", update, "rollback"},
+		},
+		{
+			name:    "no retry of other errors",
+			fail:    failAlways(update, "23505"),
+			wantErr: true,
+			want:    []string{"begin", update, "rollback"},
+		},
+	}
+	for apiName, api := range txAPIs {
+		for _, tt := range tests {
+			t.Run(apiName+"/"+tt.name, func(t *testing.T) {
+				server := startFakeServer(t, func(int32) string { return "" })
+				dm, err := NewManager(server.config())
+				if err != nil {
+					t.Fatalf("NewManager() error = %v", err)
+				}
+				defer func() { _ = dm.Close() }()
+				server.statements()
+				if tt.fail != nil {
+					server.failWith(tt.fail())
+				}
+
+				err = api(context.Background(), dm, tt.opts, update, func() error { return tt.fnErr })
+				if (err != nil) != tt.wantErr {
+					t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.fnErr != nil && !errors.Is(err, tt.fnErr) {
+					t.Errorf("error = %v, want the error of fn", err)
+				}
+				if got := server.statements(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("statements = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// TestWithTxOptions tests the transaction modes and the rollback of a panicking fn
+func TestWithTxOptions(t *testing.T) {
+	server := startFakeServer(t, func(int32) string { return "" })
+	dm, err := NewManager(server.config())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer func() { _ = dm.Close() }()
+	opts := TxOptions{IsoLevel: pgx.Serializable, ReadOnly: true, Deferrable: true}
+	const query = "SELECT 1"
+
+	wantBegin := map[string][]string{
+		"pgx":          {"begin isolation level serializable read only deferrable"},
+		"database/sql": {"begin isolation level serializable read only", "SET TRANSACTION DEFERRABLE"},
+	}
+	for apiName, api := range txAPIs {
+		server.statements()
+		if err := api(context.Background(), dm, opts, query, func() error { return nil }); err != nil {
+			t.Errorf("%s error = %v", apiName, err)
+		}
+		want := append(wantBegin[apiName], query, "commit")
+		if got := server.statements(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s statements = %q, want %q", apiName, got, want)
+		}
+
+		func() {
+			defer func() {
+				if p := recover(); p != "boom" {
+					t.Errorf("%s recovered %v, want the panic of fn", apiName, p)
+				}
+				if got := server.statements(); len(got) == 0 || got[len(got)-1] != "rollback" {
+					t.Errorf("%s statements = %q, want a rollback", apiName, got)
+				}
+			}()
+			_ = api(context.Background(), dm, TxOptions{}, query, func() error { panic("boom") })
+		}()
+	}
+
+	if err := dm.WithSQLTx(context.Background(), TxOptions{IsoLevel: "snapshot"}, nil); err == nil ||
+		!strings.Contains(err.Error(), "isolation level") {
+		t.Errorf("WithSQLTx() with an unknown isolation level error = %v", err)
+	}
+}
+
+// failOnce returns fail functions failing the first run of query with code
+func failOnce(query, code string) func() func(string) string {
+	return func() func(string) string {
+		var failed atomic.Bool
+		return func(q string) string {
+			if q == query && failed.CompareAndSwap(false, true) {
+				return code
+			}
+			return ""
+		}
+	}
+}
+
+// failAlways returns fail functions failing every run of query with code
+func failAlways(query, code string) func() func(string) string {
+	return func() func(string) string {
+		return func(q string) string {
+			if q == query {
+				return code
+			}
+			return ""
+		}
+	}
+}
@@ -55,6 +55,23 @@ func SetupPostGISContainer(ctx context.Context) (*PostgresContainer, error) {
 	}, nil
 }
 
+// Config returns a PostgresDatabase pointing at the container, with small pool settings
+// suited to tests
+func (c *PostgresContainer) Config(ctx context.Context) (*config.PostgresDatabase, error) {
+	// Extract connection information from the container
+	host, err := c.Host(ctx)
+	if err != nil {
+		return nil, err
+	}
+	port, err := c.MappedPort(ctx, "5432")
+	if err != nil {
+		return nil, err
+	}
+
+	return config.NewPostgresDatabaseForProfile(config.ProfileTest, host, port.Int(),
+		testdbName, testdbUser, testdbPassword), nil
+}
+
 // TestManagerOptions sets behavior of SetupTestManager
 type TestManagerOptions struct {
 	// MigrationsPath path to migration files (default: "/migrations" at project root)
@@ -115,33 +132,12 @@ func SetupTestManager(t testing.TB, opts ...TestManagerOptions) (*database.Manag
 		t.Fatalf("Failed to start PostGIS container: %v", err)
 	}
 
-	// Extract connection information from the container
-	host, err := pgContainer.Host(ctx)
+	cfg, err := pgContainer.Config(ctx)
 	if err != nil {
 		_ = pgContainer.Terminate(ctx)
-		t.Fatalf("Failed to get container host: %v", err)
+		t.Fatalf("Failed to get container configuration: %v", err)
 	}
 
-	port, err := pgContainer.MappedPort(ctx, "5432")
-	if err != nil {
-		_ = pgContainer.Terminate(ctx)
-		t.Fatalf("Failed to get container port: %v", err)
-	}
-
-	// Create config with individual parameters
-	cfg := config.NewPostgresDatabase(
-		host,
-		port.Int(),
-		testdbName,
-		testdbUser,
-		testdbPassword,
-	)
-
-	// Specific configuration for tests
-	cfg.MaxOpenConns = 5
-	cfg.MaxIdleConns = 2
-	cfg.PingTimeout = 10
-
 	var databaseManager *database.Manager
 
 	// Create Manager with or without migrations
@@ -0,0 +1,79 @@
+package testutils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pixime-net/mapbot-shared/config"
+)
+
+// UpdateDocsEnvVar is the environment variable that makes AssertGeneratedFile rewrite
+// stale files instead of failing, e.g. UPDATE_GENERATED_DOCS=1 go test ./...
+const UpdateDocsEnvVar = "UPDATE_GENERATED_DOCS"
+
+// AssertGeneratedFile fails the test when the committed file at path differs from the
+// output of generate, naming the first line that differs. When UPDATE_GENERATED_DOCS is
+// set, the file is rewritten instead.
+func AssertGeneratedFile(t testing.TB, path string, generate func(io.Writer) error) {
+	t.Helper()
+
+	var want bytes.Buffer
+	if err := generate(&want); err != nil {
+		t.Fatalf("Failed to generate %s: %v", path, err)
+	}
+	if os.Getenv(UpdateDocsEnvVar) != "" {
+		if err := os.WriteFile(path, want.Bytes(), 0o644); err != nil { // #nosec G306 -- documentation
+			t.Fatalf("Failed to update %s: %v", path, err)
+		}
+		return
+	}
+
+	got, err := os.ReadFile(path) // #nosec G304 -- path chosen by the test
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v (run the tests with %s=1 to generate it)", path, err, UpdateDocsEnvVar)
+	}
+	if bytes.Equal(got, want.Bytes()) {
+		return
+	}
+	gotLines := strings.Split(string(got), "\n")
+	wantLines := strings.Split(want.String(), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var gotLine, wantLine string
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if gotLine != wantLine {
+			t.Errorf("%s is stale (run the tests with %s=1 to regenerate it), line %d:\n got: %s\nwant: %s",
+				path, UpdateDocsEnvVar, i+1, gotLine, wantLine)
+			return
+		}
+	}
+}
+
+// AssertConfigDocs fails the test when the Markdown reference or the .env template of
+// cfg, a configuration struct documented with config.NewReference, are stale. An empty
+// path skips the corresponding file:
+//
+//	func TestConfigDocs(t *testing.T) {
+//		testutils.AssertConfigDocs(t, ServiceConfig{}, "MAPBOT_", "docs/configuration.md", ".env.example")
+//	}
+func AssertConfigDocs(t testing.TB, cfg any, envPrefix, markdownPath, dotEnvPath string) {
+	t.Helper()
+
+	ref, err := config.NewReference(cfg, envPrefix)
+	if err != nil {
+		t.Fatalf("Failed to document configuration: %v", err)
+	}
+	if markdownPath != "" {
+		AssertGeneratedFile(t, markdownPath, ref.WriteMarkdown)
+	}
+	if dotEnvPath != "" {
+		AssertGeneratedFile(t, dotEnvPath, ref.WriteDotEnv)
+	}
+}
@@ -0,0 +1,66 @@
+package testutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pixime-net/mapbot-shared/config"
+)
+
+// TestConfigDocs checks the committed configuration reference, see make docs
+func TestConfigDocs(t *testing.T) {
+	AssertConfigDocs(t, config.PostgresDatabase{}, config.DefaultEnvPrefix,
+		filepath.Join("..", "docs", "configuration.md"), filepath.Join("..", ".env.example"))
+}
+
+// failureRecorder records the failures of a test instead of reporting them
+type failureRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *failureRecorder) Helper() {}
+
+func (r *failureRecorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *failureRecorder) Fatalf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// TestAssertGeneratedFile tests that stale files are reported, and rewritten on request
+func TestAssertGeneratedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generated.md")
+	generate := func(w io.Writer) error {
+		_, err := io.WriteString(w, "line 1\nline 2\n")
+		return err
+	}
+	if err := os.WriteFile(path, []byte("line 1\nold line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(UpdateDocsEnvVar, "")
+	recorder := &failureRecorder{TB: t}
+	AssertGeneratedFile(recorder, path, generate)
+	if len(recorder.failures) != 1 {
+		t.Fatalf("failures = %q, want one", recorder.failures)
+	}
+	want := fmt.Sprintf("%s is stale (run the tests with %s=1 to regenerate it), line 2:\n got: old line\nwant: line 2",
+		path, UpdateDocsEnvVar)
+	if recorder.failures[0] != want {
+		t.Errorf("failure = %q, want %q", recorder.failures[0], want)
+	}
+
+	t.Setenv(UpdateDocsEnvVar, "1")
+	AssertGeneratedFile(t, path, generate)
+	t.Setenv(UpdateDocsEnvVar, "")
+	recorder = &failureRecorder{TB: t}
+	AssertGeneratedFile(recorder, path, generate)
+	if len(recorder.failures) != 0 {
+		t.Errorf("failures after update = %q", recorder.failures)
+	}
+}